@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+	"time"
+)
+
+// authAdminRequest checks the admin API key of the request and
+// returns true when the request is authorized to use the admin API.
+func (s *ServerHandler) authAdminRequest(w http.ResponseWriter, r *http.Request) bool {
+	return s.authAdminCredential(w, r, s.credentials.Take(r))
+}
+
+// isAdminCredential checks if the given credential is the admin API key
+func (s *ServerHandler) isAdminCredential(credential *requestCredential) bool {
+	return len(s.adminApiKey) > 0 && credential != nil &&
+		subtle.ConstantTimeCompare([]byte(credential.Value), []byte(s.adminApiKey)) == 1
+}
+
+// authAdminCredential checks the given credential of the request like authAdminRequest
+func (s *ServerHandler) authAdminCredential(w http.ResponseWriter, r *http.Request, credential *requestCredential) bool {
+	clientIP := s.ipResolver.ClientIP(r)
+	if allowed, reason := s.ipRules.Check(clientIP); !allowed {
+		s.rejectForbiddenIP(w, clientIP, "", reason)
+		return false
+	}
+	if s.rejectBlockedClient(w, clientIP) {
+		return false
+	}
+	if len(s.adminApiKey) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Not Found: Admin API disabled")
+		return false
+	}
+	if !s.isAdminCredential(credential) {
+		if credential != nil {
+			s.recordAuthFailure(clientIP)
+		}
+		s.rejectUnauthorized(w, "Invalid admin API key")
+		securityEvent("admin_auth_failure", "clientIp", clientIP, "method", r.Method, "url", r.URL)
+		return false
+	}
+	s.recordAuthSuccess(clientIP)
+	return true
+}
+
+// writeJson replies with the given value as JSON
+func writeJson(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
+
+// ServeHttpAdminBans will be called by the http server to list the client IPs banned due to failed authentication
+func (s *ServerHandler) ServeHttpAdminBans(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	bans := make([]BanEntry, 0)
+	if s.bruteForce != nil {
+		bans = s.bruteForce.Bans()
+	}
+	writeJson(w, http.StatusOK, map[string]any{"bans": bans})
+}
+
+// ServeHttpAdminUnban will be called by the http server to lift the ban of a client IP
+func (s *ServerHandler) ServeHttpAdminUnban(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	ip, err := netip.ParseAddr(r.PathValue("ip"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Bad Request: Invalid IP")
+		return
+	}
+	if s.bruteForce == nil || !s.bruteForce.Unban(ip.Unmap()) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintln(w, "Not Found: IP isn't banned")
+		return
+	}
+	slog.Info(fmt.Sprintf("Lifted ban of client IP %s", ip))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// adminKeyInfo describes a key in responses of the admin API, the key value is only included when it was generated
+type adminKeyInfo struct {
+	Name                string         `json:"name"`
+	Key                 string         `json:"key,omitempty"`
+	Source              string         `json:"source"`
+	Disabled            bool           `json:"disabled"`
+	Scopes              []string       `json:"scopes"`
+	AllowedModels       []string       `json:"allowed_models"`
+	Rewrite             *RewritePolicy `json:"rewrite,omitempty"`
+	ClientCertSubjects  []string       `json:"client_cert_subjects,omitempty"`
+	AllowedCidrs        []string       `json:"allowed_cidrs,omitempty"`
+	DeniedCidrs         []string       `json:"denied_cidrs,omitempty"`
+	OverrideGlobalCidrs bool           `json:"override_global_cidrs,omitempty"`
+	ValidFrom           *time.Time     `json:"valid_from,omitempty"`
+	ExpiresAt           *time.Time     `json:"expires_at,omitempty"`
+	LastUsed            *time.Time     `json:"last_used"`
+}
+
+// adminKeyInfoOf returns the description of a key for the admin API
+func (s *ServerHandler) adminKeyInfoOf(key *ApiKey) adminKeyInfo {
+	info := adminKeyInfo{
+		Name:                key.Name,
+		Source:              "env",
+		Disabled:            key.Disabled,
+		Scopes:              key.Scopes,
+		AllowedModels:       key.AllowedModels,
+		Rewrite:             key.Rewrite,
+		ClientCertSubjects:  key.ClientCertSubjects,
+		AllowedCidrs:        key.AllowedCidrs,
+		DeniedCidrs:         key.DeniedCidrs,
+		OverrideGlobalCidrs: key.OverrideGlobalCidrs,
+		ValidFrom:           key.ValidFrom,
+		ExpiresAt:           key.ExpiresAt,
+	}
+	if key.fromFile {
+		info.Source = "file"
+	}
+	if info.Scopes == nil {
+		info.Scopes = []string{}
+	}
+	if info.AllowedModels == nil {
+		info.AllowedModels = []string{}
+	}
+	if lastUsed, found := s.keyStore.LastUsed(key.Name); found {
+		info.LastUsed = &lastUsed
+	}
+	return info
+}
+
+// writeKeyStoreError replies with the status matching an error of the key store
+func writeKeyStoreError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, ErrKeyNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, "Not Found: %s\n", err)
+	case errors.Is(err, ErrKeyExists), errors.Is(err, ErrKeyReadOnly), errors.Is(err, ErrKeyNoFile):
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintf(w, "Conflict: %s\n", err)
+	case errors.Is(err, ErrKeyNotSaved):
+		slog.Error("Key store changed in memory only", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintf(w, "Internal Server Error: %s\n", err)
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Bad Request: %s\n", err)
+	}
+}
+
+// generateKeyValue returns a new random key value
+func generateKeyValue() (string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", err
+	}
+	return "sk-" + base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// ServeHttpAdminKeys will be called by the http server to list all keys
+func (s *ServerHandler) ServeHttpAdminKeys(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	keys := make([]adminKeyInfo, 0)
+	for _, key := range s.keyStore.Keys() {
+		keys = append(keys, s.adminKeyInfoOf(key))
+	}
+	writeJson(w, http.StatusOK, map[string]any{"keys": keys})
+}
+
+// ServeHttpAdminKey will be called by the http server to show a single key
+func (s *ServerHandler) ServeHttpAdminKey(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	key := s.keyStore.Get(r.PathValue("name"))
+	if key == nil {
+		writeKeyStoreError(w, fmt.Errorf("key %s: %w", r.PathValue("name"), ErrKeyNotFound))
+		return
+	}
+	writeJson(w, http.StatusOK, s.adminKeyInfoOf(key))
+}
+
+// ServeHttpAdminCreateKey will be called by the http server to create a key,
+// the key value gets generated when the request doesn't provide one.
+func (s *ServerHandler) ServeHttpAdminCreateKey(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	var key ApiKey
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&key); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, "Bad Request: Invalid key: %s\n", err)
+		return
+	}
+	generated := false
+	if len(strings.TrimSpace(key.Key)) == 0 && len(key.ClientCertSubjects) == 0 {
+		value, err := generateKeyValue()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprintln(w, "Internal Server Error: Failed to generate key")
+			return
+		}
+		key.Key = value
+		generated = true
+	}
+	if err := s.keyStore.Add(&key); err != nil {
+		writeKeyStoreError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("Created API key %s", key.Name))
+	info := s.adminKeyInfoOf(&key)
+	if generated {
+		info.Key = key.Key
+	}
+	writeJson(w, http.StatusCreated, info)
+}
+
+// ServeHttpAdminDeleteKey will be called by the http server to delete a key
+func (s *ServerHandler) ServeHttpAdminDeleteKey(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+	if err := s.keyStore.Delete(name); err != nil {
+		writeKeyStoreError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("Deleted API key %s", name))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ServeHttpAdminDisableKey will be called by the http server to disable a key
+func (s *ServerHandler) ServeHttpAdminDisableKey(w http.ResponseWriter, r *http.Request) {
+	s.updateAdminKey(w, r, "Disabled", func(key *ApiKey) error {
+		key.Disabled = true
+		return nil
+	})
+}
+
+// ServeHttpAdminEnableKey will be called by the http server to enable a disabled key
+func (s *ServerHandler) ServeHttpAdminEnableKey(w http.ResponseWriter, r *http.Request) {
+	s.updateAdminKey(w, r, "Enabled", func(key *ApiKey) error {
+		key.Disabled = false
+		return nil
+	})
+}
+
+// ServeHttpAdminRotateKey will be called by the http server to replace the value of a key by a generated one
+func (s *ServerHandler) ServeHttpAdminRotateKey(w http.ResponseWriter, r *http.Request) {
+	s.updateAdminKey(w, r, "Rotated", func(key *ApiKey) error {
+		value, err := generateKeyValue()
+		if err != nil {
+			return err
+		}
+		key.Key = value
+		return nil
+	})
+}
+
+// updateAdminKey applies the modification to the key named in the request and replies with the modified key
+func (s *ServerHandler) updateAdminKey(w http.ResponseWriter, r *http.Request, action string, modify func(key *ApiKey) error) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	name := r.PathValue("name")
+	key, err := s.keyStore.Update(name, modify)
+	if err != nil {
+		writeKeyStoreError(w, err)
+		return
+	}
+	slog.Info(fmt.Sprintf("%s API key %s", action, name))
+	info := s.adminKeyInfoOf(key)
+	if action == "Rotated" {
+		info.Key = key.Key
+	}
+	writeJson(w, http.StatusOK, info)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// eventKeepAliveInterval is the interval of comments sent to keep idle event streams open
+const eventKeepAliveInterval = 15 * time.Second
+
+// ServeHttpAdminEvents will be called by the http server to stream the events of proxied requests as server-sent events,
+// optionally filtered by the query parameters "key", "model" and "route". Request bodies are only included
+// when the subscriber asks for them by "bodies=true" and including them is enabled.
+func (s *ServerHandler) ServeHttpAdminEvents(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		w.WriteHeader(http.StatusInternalServerError)
+		fmt.Fprintln(w, "Internal Server Error: Streaming not supported")
+		return
+	}
+	query := r.URL.Query()
+	filter := EventFilter{
+		KeyName: query.Get("key"),
+		Model:   query.Get("model"),
+		Route:   query.Get("route"),
+		Bodies:  strings.ToLower(query.Get("bodies")) == "true",
+	}
+	if filter.Bodies && !s.eventBodies {
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintln(w, "Forbidden: Request bodies in events are disabled")
+		return
+	}
+
+	subscriber := s.events.Subscribe(filter)
+	defer s.events.Unsubscribe(subscriber)
+	slog.Info(fmt.Sprintf("Event stream subscribed by %s", s.ipResolver.ClientIP(r)),
+		"key", filter.KeyName, "model", filter.Model, "route", filter.Route, "bodies", filter.Bodies)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, ": connected\n\n")
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAliveInterval)
+	defer keepAlive.Stop()
+	reportedDropped := int64(0)
+	for {
+		select {
+		case <-r.Context().Done():
+			slog.Info(fmt.Sprintf("Event stream closed by %s", s.ipResolver.ClientIP(r)), "dropped", subscriber.Dropped())
+			return
+		case <-keepAlive.C:
+			if dropped := subscriber.Dropped(); dropped > reportedDropped {
+				fmt.Fprintf(w, "event: dropped\ndata: {\"dropped\":%d}\n\n", dropped)
+				reportedDropped = dropped
+			} else {
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			flusher.Flush()
+		case event := <-subscriber.Events:
+			data, err := json.Marshal(event)
+			if err != nil {
+				slog.Error("Failed to encode event", "error", err)
+				continue
+			}
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// adminUpstreamTimeout is the timeout of upstream requests of the admin API
+const adminUpstreamTimeout = 5 * time.Second
+
+// adminStatus is the overall status of proxy and upstream
+type adminStatus struct {
+	StartedAt     time.Time      `json:"started_at"`
+	UptimeSeconds int64          `json:"uptime_seconds"`
+	InFlight      int            `json:"in_flight"`
+	Upstream      upstreamStatus `json:"upstream"`
+	Preload       preloadStatus  `json:"preload"`
+}
+
+type upstreamStatus struct {
+	URL                string        `json:"url"`
+	Reachable          bool          `json:"reachable"`
+	Version            string        `json:"version,omitempty"`
+	Error              string        `json:"error,omitempty"`
+	LastSuccessfulPing *time.Time    `json:"last_successful_ping,omitempty"`
+	History            []HealthProbe `json:"history"`
+}
+
+type preloadStatus struct {
+	Status   string          `json:"status"`
+	Progress PreloadProgress `json:"progress"`
+	Models   []ModelPreload  `json:"models"`
+	Pulls    []PullProgress  `json:"pulls"`
+}
+
+// adminModelRequest is the body of model related admin requests
+type adminModelRequest struct {
+	Model string `json:"model"`
+}
+
+// String returns a readable name of the preload status
+func (p PreloadModelStatus) String() string {
+	switch p {
+	case InProgress:
+		return "in_progress"
+	case Preloaded:
+		return "preloaded"
+	}
+	return "unknown"
+}
+
+// upstreamClient returns an ollama API client for the upstream
+func (s *ServerHandler) upstreamClient() *api.Client {
+	return api.NewClient(s.upstreamBaseURL, &http.Client{Timeout: adminUpstreamTimeout})
+}
+
+// ServeHttpAdminStatus will be called by the http server to show the status of proxy, upstream and preloading
+func (s *ServerHandler) ServeHttpAdminStatus(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	status := adminStatus{
+		StartedAt:     s.startedAt,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		InFlight:      s.requests.Len(),
+		Upstream:      upstreamStatus{URL: s.upstreamBaseURL.String()},
+		Preload: preloadStatus{
+			Status:   s.preloads.Status().String(),
+			Progress: s.preloads.Overall(),
+			Models:   s.preloads.Models(),
+			Pulls:    s.pulls.Pulls(),
+		},
+	}
+	health := s.health.Status()
+	status.Upstream.Reachable = health.Healthy
+	status.Upstream.Version = health.Version
+	status.Upstream.Error = health.Error
+	status.Upstream.LastSuccessfulPing = health.LastSuccess
+	status.Upstream.History = health.History
+	writeJson(w, http.StatusOK, status)
+}
+
+// ServeHttpAdminPs will be called by the http server to list the models loaded by the upstream
+func (s *ServerHandler) ServeHttpAdminPs(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	running, err := s.upstreamClient().ListRunning(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, "Bad Gateway: %s\n", err)
+		return
+	}
+	writeJson(w, http.StatusOK, running)
+}
+
+// ServeHttpAdminRequests will be called by the http server to list the requests that are currently proxied
+func (s *ServerHandler) ServeHttpAdminRequests(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{"requests": s.requests.Requests()})
+}
+
+// ServeHttpAdminUsage will be called by the http server to show the usage per key since start of the proxy
+func (s *ServerHandler) ServeHttpAdminUsage(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	writeJson(w, http.StatusOK, map[string]any{"usage": s.keyUsage.Usage()})
+}
+
+// ServeHttpAdminPullModel will be called by the http server to start pulling a model in the background
+func (s *ServerHandler) ServeHttpAdminPullModel(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	model, ok := decodeAdminModelRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.pulls.Start(model) {
+		w.WriteHeader(http.StatusConflict)
+		fmt.Fprintln(w, "Conflict: Model is already being pulled")
+		return
+	}
+	go func() {
+		slog.Info(fmt.Sprintf("Pulling model %s...", model))
+		client := api.NewClient(s.upstreamBaseURL, http.DefaultClient)
+		err := client.Pull(s.ctx, &api.PullRequest{Model: model}, func(resp api.ProgressResponse) error {
+			s.pulls.Progress(model, resp.Status, resp.Completed, resp.Total)
+			return nil
+		})
+		if err == nil {
+			// the client ends an aborted stream without error
+			err = s.ctx.Err()
+		}
+		s.pulls.Finish(model, err)
+		if err != nil {
+			slog.Error("Failed to pull", "model", model, "error", err)
+		} else {
+			slog.Info(fmt.Sprintf("Pulled model %s", model))
+		}
+	}()
+	writeJson(w, http.StatusAccepted, map[string]string{"model": model, "status": "pulling"})
+}
+
+// ServeHttpAdminUnloadModel will be called by the http server to unload a model from memory of the upstream
+func (s *ServerHandler) ServeHttpAdminUnloadModel(w http.ResponseWriter, r *http.Request) {
+	if !s.authAdminRequest(w, r) {
+		return
+	}
+	model, ok := decodeAdminModelRequest(w, r)
+	if !ok {
+		return
+	}
+	request := &api.GenerateRequest{Model: model, KeepAlive: &api.Duration{Duration: 0}}
+	if err := s.upstreamClient().Generate(r.Context(), request, func(api.GenerateResponse) error { return nil }); err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		fmt.Fprintf(w, "Bad Gateway: %s\n", err)
+		return
+	}
+	slog.Info(fmt.Sprintf("Unloaded model %s", model))
+	writeJson(w, http.StatusOK, map[string]string{"model": model, "status": "unloaded"})
+}
+
+// decodeAdminModelRequest decodes the model of an admin request, returns false when the request got rejected
+func decodeAdminModelRequest(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var request adminModelRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || len(strings.TrimSpace(request.Model)) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Bad Request: Missing model")
+		return "", false
+	}
+	return strings.TrimSpace(request.Model), true
+}
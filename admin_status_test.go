@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForPull waits until the pull of the model matches the condition
+func waitForPull(t *testing.T, pulls *PullTracker, model string, condition func(pull PullProgress) bool) PullProgress {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, pull := range pulls.Pulls() {
+			if pull.Model == model && condition(pull) {
+				return pull
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("pulls = %+v, condition of pull of %s not met", pulls.Pulls(), model)
+	return PullProgress{}
+}
+
+func TestAdminPullModelStopsWithServer(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"pulling manifest"}` + "\n"))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := newTestServerHandler(t)
+	s.SetContext(ctx)
+	s.SetUpstreamURL(upstreamURL)
+	s.SetAdminApiKey("admin-key-value")
+
+	r := httptest.NewRequest(http.MethodPost, "/admin/models/pull", strings.NewReader(`{"model": "qwen3:8b"}`))
+	r.Header.Set("Authorization", "Bearer admin-key-value")
+	w := httptest.NewRecorder()
+	s.ServeHttpAdminPullModel(w, r)
+	if w.Code != http.StatusAccepted {
+		t.Fatalf("status = %d, want 202", w.Code)
+	}
+	waitForPull(t, s.pulls, "qwen3:8b", func(pull PullProgress) bool { return pull.Status == "pulling manifest" })
+
+	cancel()
+
+	pull := waitForPull(t, s.pulls, "qwen3:8b", func(pull PullProgress) bool { return pull.Done })
+	if pull.Status != "failed" || len(pull.Error) == 0 {
+		t.Errorf("pull = %+v, want pull aborted", pull)
+	}
+}
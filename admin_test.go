@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestAdminServerHandler returns a server handler with admin API and a key store file containing the given keys
+func newTestAdminServerHandler(t *testing.T, keys string) *ServerHandler {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(keys), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServerHandler(t)
+	if err := s.keyStore.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	s.SetAdminApiKey("admin-key-value")
+	return s
+}
+
+// serveAdmin runs the admin handler for a request with the admin API key
+func serveAdmin(handler http.HandlerFunc, method string, target string, name string, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer admin-key-value")
+	r.SetPathValue("name", name)
+	w := httptest.NewRecorder()
+	handler(w, r)
+	return w
+}
+
+// decodeAdminKey decodes the key of an admin API response
+func decodeAdminKey(t *testing.T, w *httptest.ResponseRecorder) adminKeyInfo {
+	t.Helper()
+	var info adminKeyInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Fatalf("invalid response %q: %s", w.Body, err)
+	}
+	return info
+}
+
+func TestAdminCreateKey(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": []}`)
+
+	w := serveAdmin(s.ServeHttpAdminCreateKey, http.MethodPost, "/admin/keys", "", `{"name": "key-1", "scopes": ["chat"]}`)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("status = %d, want 201: %s", w.Code, w.Body)
+	}
+	info := decodeAdminKey(t, w)
+	if !strings.HasPrefix(info.Key, "sk-") || info.Source != "file" {
+		t.Errorf("created key = %+v, want generated value of file key", info)
+	}
+	if key := s.keyStore.Lookup(info.Key); key == nil || key.Name != "key-1" {
+		t.Errorf("generated value doesn't authenticate key-1")
+	}
+
+	w = serveAdmin(s.ServeHttpAdminCreateKey, http.MethodPost, "/admin/keys", "", `{"name": "key-2", "key": "value-2"}`)
+	if w.Code != http.StatusCreated || decodeAdminKey(t, w).Key != "" {
+		t.Errorf("status = %d, body %s, want provided value not returned", w.Code, w.Body)
+	}
+	w = serveAdmin(s.ServeHttpAdminCreateKey, http.MethodPost, "/admin/keys", "", `{"name": "key-2", "key": "value-3"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409 for existing name", w.Code)
+	}
+}
+
+func TestAdminCreateKeyWithoutFile(t *testing.T) {
+	s := newTestServerHandler(t)
+	s.SetAdminApiKey("admin-key-value")
+
+	w := serveAdmin(s.ServeHttpAdminCreateKey, http.MethodPost, "/admin/keys", "", `{"name": "key-1"}`)
+	if w.Code != http.StatusConflict {
+		t.Errorf("status = %d, want 409", w.Code)
+	}
+}
+
+func TestAdminKeysNeverListValues(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": [{"name": "key-1", "key": "secret-value-1"}]}`)
+
+	for _, w := range []*httptest.ResponseRecorder{
+		serveAdmin(s.ServeHttpAdminKeys, http.MethodGet, "/admin/keys", "", ""),
+		serveAdmin(s.ServeHttpAdminKey, http.MethodGet, "/admin/keys/key-1", "key-1", ""),
+		serveAdmin(s.ServeHttpAdminDisableKey, http.MethodPost, "/admin/keys/key-1/disable", "key-1", ""),
+	} {
+		if w.Code != http.StatusOK {
+			t.Errorf("status = %d, want 200", w.Code)
+		}
+		if body := w.Body.String(); !strings.Contains(body, "key-1") || strings.Contains(body, "secret-value-1") {
+			t.Errorf("body = %s, want key without value", body)
+		}
+	}
+}
+
+func TestAdminDisableAndEnableKey(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": [{"name": "key-1", "key": "value-1"}]}`)
+	userRequest := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		r.Header.Set("Authorization", "Bearer value-1")
+		return r
+	}
+
+	w := serveAdmin(s.ServeHttpAdminDisableKey, http.MethodPost, "/admin/keys/key-1/disable", "key-1", "")
+	if w.Code != http.StatusOK || !decodeAdminKey(t, w).Disabled {
+		t.Errorf("status = %d, body %s, want disabled key", w.Code, w.Body)
+	}
+	identity, _ := authenticate(s, userRequest())
+	if identity != nil {
+		t.Error("disabled key authenticated")
+	}
+
+	w = serveAdmin(s.ServeHttpAdminEnableKey, http.MethodPost, "/admin/keys/key-1/enable", "key-1", "")
+	if w.Code != http.StatusOK || decodeAdminKey(t, w).Disabled {
+		t.Errorf("status = %d, body %s, want enabled key", w.Code, w.Body)
+	}
+	identity, _ = authenticate(s, userRequest())
+	if identity == nil || identity.Name != "key-1" {
+		t.Errorf("identity = %+v, want enabled key authenticated", identity)
+	}
+
+	w = serveAdmin(s.ServeHttpAdminDisableKey, http.MethodPost, "/admin/keys/key-2/disable", "key-2", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for unknown key", w.Code)
+	}
+}
+
+func TestAdminRotateKey(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": [{"name": "key-1", "key": "value-1"}]}`)
+
+	w := serveAdmin(s.ServeHttpAdminRotateKey, http.MethodPost, "/admin/keys/key-1/rotate", "key-1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200: %s", w.Code, w.Body)
+	}
+	value := decodeAdminKey(t, w).Key
+	if !strings.HasPrefix(value, "sk-") {
+		t.Errorf("rotated value = %q, want generated value", value)
+	}
+	if s.keyStore.Lookup("value-1") != nil {
+		t.Error("old value still authenticates")
+	}
+	if key := s.keyStore.Lookup(value); key == nil || key.Name != "key-1" {
+		t.Error("rotated value doesn't authenticate key-1")
+	}
+}
+
+func TestAdminDeleteKey(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": [{"name": "key-1", "key": "value-1"}]}`)
+
+	w := serveAdmin(s.ServeHttpAdminDeleteKey, http.MethodDelete, "/admin/keys/key-1", "key-1", "")
+	if w.Code != http.StatusNoContent {
+		t.Errorf("status = %d, want 204", w.Code)
+	}
+	if s.keyStore.Get("key-1") != nil {
+		t.Error("deleted key still present")
+	}
+	w = serveAdmin(s.ServeHttpAdminDeleteKey, http.MethodDelete, "/admin/keys/key-1", "key-1", "")
+	if w.Code != http.StatusNotFound {
+		t.Errorf("status = %d, want 404 for deleted key", w.Code)
+	}
+}
+
+func TestAdminRequiresAdminApiKey(t *testing.T) {
+	s := newTestAdminServerHandler(t, `{"keys": [{"name": "key-1", "key": "value-1"}]}`)
+
+	r := httptest.NewRequest(http.MethodDelete, "/admin/keys/key-1", nil)
+	r.Header.Set("Authorization", "Bearer value-1")
+	r.SetPathValue("name", "key-1")
+	w := httptest.NewRecorder()
+	s.ServeHttpAdminDeleteKey(w, r)
+
+	if w.Code != http.StatusUnauthorized || s.keyStore.Get("key-1") == nil {
+		t.Errorf("status = %d, want key deletion with API key of user rejected", w.Code)
+	}
+}
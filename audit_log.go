@@ -0,0 +1,214 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// defaultAuditRedactions are patterns of sensitive data that are always redacted in the audit log
+var defaultAuditRedactions = []string{
+	// email addresses
+	`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	// bearer tokens and typical API tokens
+	`(?i)bearer\s+[A-Za-z0-9._~+/=-]+`,
+	`\b(?:sk|pk|rk|ghp|gho|xox[abp])[-_][A-Za-z0-9_-]{16,}\b`,
+}
+
+// auditCardPattern matches candidates of credit card numbers, only numbers passing the Luhn check get redacted
+var auditCardPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+
+// AuditRecord is a single entry of the audit log
+type AuditRecord struct {
+	Time       time.Time `json:"time"`
+	RequestId  string    `json:"request_id"`
+	KeyName    string    `json:"key_name,omitempty"`
+	UserId     string    `json:"user_id,omitempty"`
+	UserName   string    `json:"user_name,omitempty"`
+	Client     string    `json:"client,omitempty"`
+	Method     string    `json:"method"`
+	Route      string    `json:"route"`
+	Model      string    `json:"model,omitempty"`
+	Status     int       `json:"status"`
+	DurationMs int64     `json:"duration_ms"`
+	Request    string    `json:"request,omitempty"`
+	Response   string    `json:"response,omitempty"`
+	Truncated  bool      `json:"truncated,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// AuditLog writes audit records as JSON lines to size-based rotating files
+type AuditLog struct {
+	mutex       sync.Mutex
+	path        string
+	maxSize     int64
+	maxFiles    int
+	maxBodySize int
+	redactions  []*regexp.Regexp
+	file        *os.File
+	size        int64
+}
+
+// NewAuditLog will create a new audit log writing to the given file
+func NewAuditLog(path string, maxSize int64, maxFiles int, maxBodySize int, redactPatterns []string) (*AuditLog, error) {
+	a := &AuditLog{
+		path:        path,
+		maxSize:     maxSize,
+		maxFiles:    maxFiles,
+		maxBodySize: maxBodySize,
+	}
+	for _, pattern := range append(defaultAuditRedactions, redactPatterns...) {
+		redaction, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid audit log redaction pattern %q: %w", pattern, err)
+		}
+		a.redactions = append(a.redactions, redaction)
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// MaxBodySize returns the max size of request/response text stored per record
+func (a *AuditLog) MaxBodySize() int {
+	return a.maxBodySize
+}
+
+// Redact replaces all sensitive data in the given text
+func (a *AuditLog) Redact(text string) string {
+	for _, redaction := range a.redactions {
+		text = redaction.ReplaceAllString(text, "[REDACTED]")
+	}
+	return auditCardPattern.ReplaceAllStringFunc(text, func(candidate string) string {
+		if isLuhnValid(candidate) {
+			return "[REDACTED]"
+		}
+		return candidate
+	})
+}
+
+// isLuhnValid checks the Luhn checksum of the digits of a number, ignoring separators
+func isLuhnValid(number string) bool {
+	sum, digits := 0, 0
+	for i := len(number) - 1; i >= 0; i-- {
+		if !isDigitByte(number[i]) {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if digits%2 == 1 {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		digits++
+	}
+	return digits > 0 && sum%10 == 0
+}
+
+// Log redacts, truncates and writes the given record
+func (a *AuditLog) Log(record AuditRecord) error {
+	var truncated bool
+	record.Request, truncated = truncateText(a.Redact(record.Request), a.maxBodySize)
+	record.Truncated = record.Truncated || truncated
+	record.Response, truncated = truncateText(a.Redact(record.Response), a.maxBodySize)
+	record.Truncated = record.Truncated || truncated
+
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// Close closes the current audit log file
+func (a *AuditLog) Close() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file.Close()
+}
+
+func (a *AuditLog) open() error {
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", a.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to open audit log %s: %w", a.path, err)
+	}
+	a.file = file
+	a.size = info.Size()
+	return nil
+}
+
+// rotate renames the current file to "<path>.1", shifting older files up to the max number of files
+func (a *AuditLog) rotate() error {
+	if err := a.file.Close(); err != nil {
+		return err
+	}
+	os.Remove(fmt.Sprintf("%s.%d", a.path, a.maxFiles))
+	for i := a.maxFiles - 1; i > 0; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	if a.maxFiles > 0 {
+		if err := os.Rename(a.path, a.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(a.path); err != nil {
+		return err
+	}
+	return a.open()
+}
+
+// truncateText limits text to maxSize bytes, without splitting a UTF-8 encoded rune, and reports whether it was truncated
+func truncateText(text string, maxSize int) (string, bool) {
+	if len(text) <= maxSize {
+		return text, false
+	}
+	end := maxSize
+	for end > 0 && !utf8.RuneStart(text[end]) {
+		end--
+	}
+	return text[:end], true
+}
+
+// newAuditRecord creates the audit record of a request with the given body
+func newAuditRecord(r *http.Request, requestId string, identity *Identity, data []byte) *AuditRecord {
+	record := &AuditRecord{
+		Time:      time.Now(),
+		RequestId: requestId,
+		Client:    r.RemoteAddr,
+		Method:    r.Method,
+		Route:     r.URL.Path,
+		Request:   string(data),
+	}
+	if identity != nil {
+		record.KeyName = identity.Name
+		record.UserId = identity.UserId
+		record.UserName = identity.UserName
+	}
+	if body := decodeJsonObject(data); body != nil {
+		record.Model, _ = body["model"].(string)
+	}
+	return record
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// readAuditRecords returns the records of the given audit log file
+func readAuditRecords(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]AuditRecord, 0)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if len(line) == 0 {
+			continue
+		}
+		var record AuditRecord
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditLogRedactsAndTruncates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(path, 1024*1024, 1, 40, []string{`secret-\d+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	err = auditLog.Log(AuditRecord{
+		Request:  "mail jane@example.com about secret-42",
+		Response: strings.Repeat("a", 50),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := readAuditRecords(t, path)
+	if len(records) != 1 {
+		t.Fatalf("records = %d, want 1", len(records))
+	}
+	if want := "mail [REDACTED] about [REDACTED]"; records[0].Request != want {
+		t.Errorf("request = %q, want %q", records[0].Request, want)
+	}
+	if len(records[0].Response) != 40 || !records[0].Truncated {
+		t.Errorf("response = %q, want truncated to 40 bytes", records[0].Response)
+	}
+}
+
+func TestAuditLogRotates(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(path, 100, 2, 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	for range 4 {
+		if err := auditLog.Log(AuditRecord{Request: strings.Repeat("x", 60)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, rotated := range []string{path + ".1", path + ".2"} {
+		if _, err := os.Stat(rotated); err != nil {
+			t.Errorf("rotated file %s missing: %v", rotated, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); err == nil {
+		t.Error("more rotated files kept than configured")
+	}
+}
+
+func TestAuditLogRecordsTagsWithModelAliases(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"models":[{"name":"llama3.1:8b","model":"llama3.1:8b"}]}`)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(path, 1024*1024, 1, 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	handler := NewProxyHandler(upstreamURL, nil, slog.Default())
+	handler.SetRequestContext("request-1", nil)
+	handler.SetModelAliases(ModelAliases{"default-chat": "llama3.1:8b"})
+	handler.SetAuditLog(auditLog)
+	recorder := httptest.NewRecorder()
+	handler.ProxyRequest(recorder, httptest.NewRequest(http.MethodGet, "/api/tags", nil))
+
+	if !strings.Contains(recorder.Body.String(), "default-chat") {
+		t.Errorf("body = %s, want virtual model", recorder.Body.String())
+	}
+	records := readAuditRecords(t, path)
+	if len(records) != 1 || records[0].Route != "/api/tags" || records[0].Status != http.StatusOK {
+		t.Errorf("records = %+v, want one record of /api/tags", records)
+	}
+}
+
+func TestAuditLogRedactsCardNumbersPassingLuhnCheck(t *testing.T) {
+	auditLog, err := NewAuditLog(filepath.Join(t.TempDir(), "audit.log"), 1024*1024, 1, 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	redacted := auditLog.Redact("card 4111 1111 1111 1111, created 1718000000000123")
+	if want := "card [REDACTED], created 1718000000000123"; redacted != want {
+		t.Errorf("redacted = %q, want %q", redacted, want)
+	}
+}
+
+func TestTruncateTextKeepsRunes(t *testing.T) {
+	truncated, ok := truncateText("aäb", 2)
+	if truncated != "a" || !ok || !utf8.ValidString(truncated) {
+		t.Errorf("truncated = %q (%t), want rune not split", truncated, ok)
+	}
+}
+
+func TestAuditLogRecordsFailedUpstreamCall(t *testing.T) {
+	upstreamURL, _ := url.Parse("http://127.0.0.1:1")
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(path, 1024*1024, 1, 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+
+	handler := NewProxyHandler(upstreamURL, nil, slog.Default())
+	handler.SetRequestContext("request-1", nil)
+	handler.SetAuditLog(auditLog)
+	recorder := httptest.NewRecorder()
+	handler.ProxyRequest(recorder, httptest.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(`{"model":"llama3"}`)))
+
+	records := readAuditRecords(t, path)
+	if recorder.Code != http.StatusBadGateway || len(records) != 1 || records[0].Status != http.StatusBadGateway || len(records[0].Error) == 0 {
+		t.Errorf("status %d, records = %+v, want record of failed call", recorder.Code, records)
+	}
+}
+
+func TestAuditLogRecordsModeratedRequest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := NewAuditLog(path, 1024*1024, 1, 1024, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer auditLog.Close()
+	s := newTestServerHandler(t, &ApiKey{Name: "key-1", Key: "valid-key"})
+	s.SetUpstreamURL(&url.URL{Scheme: "http", Host: "127.0.0.1:1"})
+	s.SetAuditLog(auditLog)
+	s.SetModeration(&Moderation{Moderator: &countingModerator{flag: "bad"}, Action: ModerationBlock})
+
+	r := httptest.NewRequest(http.MethodPost, "/api/generate", strings.NewReader(`{"model":"llama3","prompt":"bad"}`))
+	r.Header.Set("Authorization", "Bearer valid-key")
+	w := httptest.NewRecorder()
+	s.ServeHttpProxy(w, r)
+
+	records := readAuditRecords(t, path)
+	if w.Code != http.StatusForbidden || len(records) != 1 || records[0].Status != http.StatusForbidden ||
+		records[0].KeyName != "key-1" || records[0].Model != "llama3" {
+		t.Errorf("status %d, records = %+v, want record of rejected request", w.Code, records)
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+)
+
+// authFailures are the recent failed authentication attempts of a client IP
+type authFailures struct {
+	count        int
+	lastFailure  time.Time
+	blockedUntil time.Time
+	banned       bool
+}
+
+// BanEntry is a banned client IP
+type BanEntry struct {
+	ClientIP    string    `json:"client_ip"`
+	Failures    int       `json:"failures"`
+	LastFailure time.Time `json:"last_failure"`
+	BannedUntil time.Time `json:"banned_until"`
+}
+
+// BruteForceGuard tracks failed authentication attempts per client IP.
+// Each failure blocks the client with exponential back-off, reaching the max number of failures bans the client.
+type BruteForceGuard struct {
+	maxFailures int
+	backoff     time.Duration
+	banDuration time.Duration
+	window      time.Duration
+
+	mutex   sync.Mutex
+	clients map[netip.Addr]*authFailures
+}
+
+// NewBruteForceGuard will create a new guard, failures are forgotten when there was none within the window
+func NewBruteForceGuard(maxFailures int, backoff time.Duration, banDuration time.Duration, window time.Duration) *BruteForceGuard {
+	return &BruteForceGuard{
+		maxFailures: maxFailures,
+		backoff:     backoff,
+		banDuration: banDuration,
+		window:      window,
+		clients:     make(map[netip.Addr]*authFailures),
+	}
+}
+
+// Check returns whether the client IP is currently blocked and how long it stays blocked
+func (g *BruteForceGuard) Check(ip netip.Addr) (bool, time.Duration) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	failures, found := g.clients[ip]
+	if !found {
+		return false, 0
+	}
+	if wait := time.Until(failures.blockedUntil); wait > 0 {
+		return true, wait
+	}
+	return false, 0
+}
+
+// Failure records a failed authentication attempt of the client IP
+func (g *BruteForceGuard) Failure(ip netip.Addr) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now()
+	failures, found := g.clients[ip]
+	if !found || now.Sub(failures.lastFailure) > g.window {
+		failures = &authFailures{}
+		g.clients[ip] = failures
+	}
+	failures.count++
+	failures.lastFailure = now
+
+	if failures.count >= g.maxFailures {
+		failures.banned = true
+		failures.blockedUntil = now.Add(g.banDuration)
+		securityEvent("auth_lockout", "clientIp", ip, "failures", failures.count, "bannedUntil", failures.blockedUntil)
+		return
+	}
+	delay := g.backoff << (failures.count - 1)
+	if delay <= 0 || delay > g.banDuration {
+		delay = g.banDuration
+	}
+	failures.blockedUntil = now.Add(delay)
+}
+
+// Success forgets the failed authentication attempts of the client IP
+func (g *BruteForceGuard) Success(ip netip.Addr) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	delete(g.clients, ip)
+}
+
+// Bans returns the currently banned client IPs
+func (g *BruteForceGuard) Bans() []BanEntry {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	now := time.Now()
+	bans := make([]BanEntry, 0)
+	for ip, failures := range g.clients {
+		if failures.banned && now.Before(failures.blockedUntil) {
+			bans = append(bans, BanEntry{
+				ClientIP:    ip.String(),
+				Failures:    failures.count,
+				LastFailure: failures.lastFailure,
+				BannedUntil: failures.blockedUntil,
+			})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool { return bans[i].BannedUntil.Before(bans[j].BannedUntil) })
+	return bans
+}
+
+// Unban forgets the failed authentication attempts of a banned client IP, returns false when it wasn't banned
+func (g *BruteForceGuard) Unban(ip netip.Addr) bool {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	failures, found := g.clients[ip]
+	if !found || !failures.banned {
+		return false
+	}
+	delete(g.clients, ip)
+	securityEvent("auth_unban", "clientIp", ip)
+	return true
+}
+
+// Run removes expired entries in the given interval until the context is done
+func (g *BruteForceGuard) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			g.mutex.Lock()
+			now := time.Now()
+			for ip, failures := range g.clients {
+				if now.After(failures.blockedUntil) && now.Sub(failures.lastFailure) > g.window {
+					delete(g.clients, ip)
+				}
+			}
+			g.mutex.Unlock()
+		}
+	}
+}
+
+// securityEvent logs a structured security relevant event
+func securityEvent(event string, args ...any) {
+	slog.Warn("Security event", append([]any{"event", event}, args...)...)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestBruteForceGuardBacksOff(t *testing.T) {
+	guard := NewBruteForceGuard(5, time.Second, time.Hour, time.Hour)
+	ip := netip.MustParseAddr("198.51.100.1")
+
+	if blocked, _ := guard.Check(ip); blocked {
+		t.Fatal("client blocked without failures")
+	}
+	var previous time.Duration
+	for i := range 3 {
+		guard.Failure(ip)
+		blocked, wait := guard.Check(ip)
+		if !blocked || wait <= previous {
+			t.Fatalf("failure %d: blocked = %t for %s, want longer block than %s", i+1, blocked, wait, previous)
+		}
+		previous = wait
+	}
+	if bans := guard.Bans(); len(bans) != 0 {
+		t.Errorf("bans = %+v, want none before max failures", bans)
+	}
+	if blocked, _ := guard.Check(netip.MustParseAddr("198.51.100.2")); blocked {
+		t.Error("other client blocked")
+	}
+}
+
+func TestBruteForceGuardBans(t *testing.T) {
+	guard := NewBruteForceGuard(3, time.Millisecond, time.Hour, time.Hour)
+	ip := netip.MustParseAddr("198.51.100.1")
+
+	for range 3 {
+		guard.Failure(ip)
+	}
+
+	blocked, wait := guard.Check(ip)
+	if !blocked || wait < 59*time.Minute {
+		t.Errorf("blocked = %t for %s, want ban of an hour", blocked, wait)
+	}
+	if bans := guard.Bans(); len(bans) != 1 || bans[0].ClientIP != ip.String() || bans[0].Failures != 3 {
+		t.Errorf("bans = %+v, want ban of client", bans)
+	}
+	if !guard.Unban(ip) {
+		t.Fatal("ban not lifted")
+	}
+	if blocked, _ := guard.Check(ip); blocked {
+		t.Error("client still blocked after unban")
+	}
+	if guard.Unban(ip) {
+		t.Error("unban of client that isn't banned reported")
+	}
+}
+
+func TestBruteForceGuardForgetsOnSuccess(t *testing.T) {
+	guard := NewBruteForceGuard(3, time.Millisecond, time.Hour, time.Hour)
+	ip := netip.MustParseAddr("198.51.100.1")
+
+	guard.Failure(ip)
+	guard.Failure(ip)
+	guard.Success(ip)
+	guard.Failure(ip)
+
+	if bans := guard.Bans(); len(bans) != 0 {
+		t.Errorf("bans = %+v, want failures before success forgotten", bans)
+	}
+}
+
+func TestAuthenticateBlocksRepeatedFailures(t *testing.T) {
+	s := newTestServerHandler(t, &ApiKey{Name: "key-1", Key: "valid-key"})
+	resolver, _ := NewClientIPResolver(nil)
+	s.SetIPRules(&IPRules{}, resolver)
+	s.SetBruteForceGuard(NewBruteForceGuard(2, time.Hour, time.Hour, time.Hour))
+
+	request := func(key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+		r.RemoteAddr = "198.51.100.1:1234"
+		r.Header.Set("Authorization", "Bearer "+key)
+		_, w := authenticate(s, r)
+		return w.Code
+	}
+
+	if status := request("wrong-key"); status != http.StatusUnauthorized {
+		t.Errorf("status of invalid key = %d, want 401", status)
+	}
+	if status := request("valid-key"); status != http.StatusTooManyRequests {
+		t.Errorf("status of blocked client = %d, want 429", status)
+	}
+}
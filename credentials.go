@@ -0,0 +1,72 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	apiKeyHeader     = "X-API-Key"
+	apiKeyQueryParam = "api_key"
+)
+
+// CredentialSources enables sources of an API key besides the "Authorization: Bearer" header,
+// for clients that can't set that header.
+type CredentialSources struct {
+	// ApiKeyHeader accepts the key in header "X-API-Key"
+	ApiKeyHeader bool
+	// BasicAuth accepts the key as password of HTTP basic auth
+	BasicAuth bool
+	// QueryParam accepts the key in query parameter "api_key"
+	QueryParam bool
+}
+
+// requestCredential is the credential provided by a request
+type requestCredential struct {
+	Value  string
+	Source string
+	// Malformed is the reason why a provided credential is unusable
+	Malformed string
+}
+
+// Take extracts the credential of the request and strips all enabled credential sources from the request,
+// so that the credential neither reaches the upstream nor the logs. Returns nil when there is no credential.
+func (c CredentialSources) Take(r *http.Request) *requestCredential {
+	var credential *requestCredential
+
+	if authHeader := r.Header.Get("Authorization"); len(authHeader) > 0 {
+		scheme, value, _ := strings.Cut(authHeader, " ")
+		switch {
+		case strings.ToLower(scheme) == "bearer":
+			credential = &requestCredential{Value: value, Source: "bearer"}
+		case strings.ToLower(scheme) == "basic" && c.BasicAuth:
+			if _, password, ok := r.BasicAuth(); ok {
+				credential = &requestCredential{Value: password, Source: "basic"}
+			} else {
+				credential = &requestCredential{Malformed: "Invalid basic auth credentials"}
+			}
+		default:
+			credential = &requestCredential{Malformed: "Invalid Authorization header format"}
+		}
+	}
+	r.Header.Del("Authorization")
+
+	if c.ApiKeyHeader {
+		if value := strings.TrimSpace(r.Header.Get(apiKeyHeader)); len(value) > 0 && credential == nil {
+			credential = &requestCredential{Value: value, Source: "header"}
+		}
+		r.Header.Del(apiKeyHeader)
+	}
+
+	if c.QueryParam && r.URL.Query().Has(apiKeyQueryParam) {
+		query := r.URL.Query()
+		if value := strings.TrimSpace(query.Get(apiKeyQueryParam)); len(value) > 0 && credential == nil {
+			credential = &requestCredential{Value: value, Source: "query"}
+		}
+		query.Del(apiKeyQueryParam)
+		r.URL.RawQuery = query.Encode()
+		r.RequestURI = r.URL.RequestURI()
+	}
+
+	return credential
+}
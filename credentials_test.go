@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCredentialSourcesTake(t *testing.T) {
+	all := CredentialSources{ApiKeyHeader: true, BasicAuth: true, QueryParam: true}
+	tests := []struct {
+		name      string
+		sources   CredentialSources
+		prepare   func(r *http.Request)
+		value     string
+		source    string
+		malformed bool
+	}{
+		{"bearer", CredentialSources{}, func(r *http.Request) { r.Header.Set("Authorization", "Bearer key-1") }, "key-1", "bearer", false},
+		{"header", all, func(r *http.Request) { r.Header.Set(apiKeyHeader, "key-1") }, "key-1", "header", false},
+		{"disabled header", CredentialSources{}, func(r *http.Request) { r.Header.Set(apiKeyHeader, "key-1") }, "", "", false},
+		{"basic auth", all, func(r *http.Request) { r.SetBasicAuth("ignored", "key-1") }, "key-1", "basic", false},
+		{"disabled basic auth", CredentialSources{}, func(r *http.Request) { r.SetBasicAuth("ignored", "key-1") }, "", "", true},
+		{"query", all, func(r *http.Request) { r.URL.RawQuery = "api_key=key-1&x=1" }, "key-1", "query", false},
+		{"bearer before header", all, func(r *http.Request) {
+			r.Header.Set("Authorization", "Bearer key-1")
+			r.Header.Set(apiKeyHeader, "key-2")
+		}, "key-1", "bearer", false},
+		{"header before query", all, func(r *http.Request) {
+			r.Header.Set(apiKeyHeader, "key-1")
+			r.URL.RawQuery = "api_key=key-2"
+		}, "key-1", "header", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+			tt.prepare(r)
+			credential := tt.sources.Take(r)
+			switch {
+			case tt.malformed:
+				if credential == nil || len(credential.Malformed) == 0 {
+					t.Errorf("credential = %+v, want malformed", credential)
+				}
+			case len(tt.value) == 0:
+				if credential != nil {
+					t.Errorf("credential = %+v, want none", credential)
+				}
+			case credential == nil || credential.Value != tt.value || credential.Source != tt.source:
+				t.Errorf("credential = %+v, want %s from %s", credential, tt.value, tt.source)
+			}
+		})
+	}
+}
+
+func TestCredentialSourcesStripCredentials(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/tags?api_key=key-1&x=1", nil)
+	r.Header.Set("Authorization", "Bearer key-2")
+	r.Header.Set(apiKeyHeader, "key-3")
+
+	CredentialSources{ApiKeyHeader: true, QueryParam: true}.Take(r)
+
+	if r.Header.Get("Authorization") != "" || r.Header.Get(apiKeyHeader) != "" {
+		t.Errorf("headers = %v, want credentials removed", r.Header)
+	}
+	if r.URL.RawQuery != "x=1" || r.RequestURI != "/api/tags?x=1" {
+		t.Errorf("query = %q, request URI = %q, want api_key removed", r.URL.RawQuery, r.RequestURI)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+//go:embed dashboard/index.html
+var dashboardHtml []byte
+
+// ServeHttpDashboard will be called by the http server to provide the web dashboard for operators.
+// The page itself contains no data, it uses the admin API with the admin API key entered by the operator.
+func (s *ServerHandler) ServeHttpDashboard(w http.ResponseWriter, r *http.Request) {
+	if len(s.adminApiKey) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'unsafe-inline'; style-src 'unsafe-inline'")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Write(dashboardHtml)
+}
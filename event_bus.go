@@ -0,0 +1,171 @@
+package main
+
+import (
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// EventType is the kind of a proxy event
+type EventType string
+
+const (
+	EventRequestStart EventType = "request_start"
+	EventAuth         EventType = "auth"
+	EventUpstream     EventType = "upstream"
+	EventMetrics      EventType = "metrics"
+	EventDone         EventType = "done"
+	EventCancel       EventType = "cancel"
+	// EventModelUpdated isn't related to a request, it reports a preloaded model updated by refreshing it
+	EventModelUpdated EventType = "model_updated"
+)
+
+// eventBodyMaxSize is the max size of a request body included in an event
+const eventBodyMaxSize = 64 * 1024
+
+// eventSubscriberBuffer is the number of events buffered per subscriber, further events get dropped
+const eventSubscriberBuffer = 256
+
+// ProxyEvent is an event in the lifecycle of a proxied request or of a preloaded model
+type ProxyEvent struct {
+	Type       EventType    `json:"type"`
+	Time       time.Time    `json:"time"`
+	RequestId  string       `json:"request_id,omitempty"`
+	Method     string       `json:"method,omitempty"`
+	Route      string       `json:"route,omitempty"`
+	ClientIP   string       `json:"client_ip,omitempty"`
+	KeyName    string       `json:"key_name,omitempty"`
+	UserName   string       `json:"user_name,omitempty"`
+	Model      string       `json:"model,omitempty"`
+	Result     string       `json:"result,omitempty"`
+	Status     int          `json:"status,omitempty"`
+	DurationMs int64        `json:"duration_ms,omitempty"`
+	Metrics    *api.Metrics `json:"metrics,omitempty"`
+	Body       string       `json:"body,omitempty"`
+
+	Digest         string `json:"digest,omitempty"`
+	PreviousDigest string `json:"previous_digest,omitempty"`
+}
+
+// as returns a copy of the event with the given type and result
+func (e ProxyEvent) as(eventType EventType, result string) ProxyEvent {
+	e.Type = eventType
+	e.Time = time.Now()
+	e.Result = result
+	return e
+}
+
+// EventFilter selects the events of a subscriber, empty fields match every event.
+// Events that don't know the key or model yet ( e.g. request start ) don't match a key or model filter.
+type EventFilter struct {
+	KeyName string
+	// Model supports glob patterns like "qwen3:*"
+	Model  string
+	Route  string
+	Bodies bool
+}
+
+// Matches checks if the event is selected by the filter
+func (f EventFilter) Matches(event ProxyEvent) bool {
+	if len(f.KeyName) > 0 && event.KeyName != f.KeyName {
+		return false
+	}
+	if len(f.Model) > 0 {
+		if matched, _ := path.Match(f.Model, event.Model); !matched && event.Model != f.Model {
+			return false
+		}
+	}
+	if len(f.Route) > 0 && event.Route != f.Route {
+		return false
+	}
+	return true
+}
+
+// EventSubscriber receives the events selected by its filter
+type EventSubscriber struct {
+	Events  chan ProxyEvent
+	filter  EventFilter
+	dropped atomic.Int64
+}
+
+// Dropped returns the number of events dropped because the subscriber was too slow
+func (s *EventSubscriber) Dropped() int64 {
+	return s.dropped.Load()
+}
+
+// EventBus fans out proxy events to subscribers without ever blocking the publisher
+type EventBus struct {
+	mutex       sync.RWMutex
+	subscribers map[*EventSubscriber]struct{}
+}
+
+// NewEventBus will create a new event bus
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[*EventSubscriber]struct{}),
+	}
+}
+
+// Subscribe adds a subscriber receiving the events selected by the filter
+func (b *EventBus) Subscribe(filter EventFilter) *EventSubscriber {
+	subscriber := &EventSubscriber{
+		Events: make(chan ProxyEvent, eventSubscriberBuffer),
+		filter: filter,
+	}
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.subscribers[subscriber] = struct{}{}
+	return subscriber
+}
+
+// Unsubscribe removes the subscriber
+func (b *EventBus) Unsubscribe(subscriber *EventSubscriber) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	delete(b.subscribers, subscriber)
+}
+
+// HasSubscribers checks if anybody is listening, to skip preparing events
+func (b *EventBus) HasSubscribers() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// WantsBodies checks if any subscriber wants request bodies
+func (b *EventBus) WantsBodies() bool {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for subscriber := range b.subscribers {
+		if subscriber.filter.Bodies {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends the event to all subscribers selecting it, the body is only sent to subscribers that want it
+func (b *EventBus) Publish(event ProxyEvent) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for subscriber := range b.subscribers {
+		if !subscriber.filter.Matches(event) {
+			continue
+		}
+		e := event
+		if !subscriber.filter.Bodies {
+			e.Body = ""
+		}
+		select {
+		case subscriber.Events <- e:
+		default:
+			subscriber.dropped.Add(1)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEventFilterMatches(t *testing.T) {
+	event := ProxyEvent{KeyName: "key-1", Model: "qwen3:8b", Route: "/api/chat"}
+	tests := []struct {
+		name    string
+		filter  EventFilter
+		matches bool
+	}{
+		{"empty filter", EventFilter{}, true},
+		{"key", EventFilter{KeyName: "key-1"}, true},
+		{"other key", EventFilter{KeyName: "key-2"}, false},
+		{"model pattern", EventFilter{Model: "qwen3:*"}, true},
+		{"other model", EventFilter{Model: "gemma3*"}, false},
+		{"other route", EventFilter{Route: "/api/embed"}, false},
+	}
+	for _, tt := range tests {
+		if matches := tt.filter.Matches(event); matches != tt.matches {
+			t.Errorf("%s: matches = %t, want %t", tt.name, matches, tt.matches)
+		}
+	}
+}
+
+func TestEventBusPublish(t *testing.T) {
+	bus := NewEventBus()
+	all := bus.Subscribe(EventFilter{Bodies: true})
+	chat := bus.Subscribe(EventFilter{Route: "/api/chat"})
+
+	bus.Publish(ProxyEvent{Type: EventRequestStart, Route: "/api/chat", Body: "{}"})
+	bus.Publish(ProxyEvent{Type: EventRequestStart, Route: "/api/embed"})
+
+	if len(all.Events) != 2 || len(chat.Events) != 1 {
+		t.Fatalf("events = %d and %d, want 2 and 1", len(all.Events), len(chat.Events))
+	}
+	if event := <-all.Events; event.Body != "{}" || event.Time.IsZero() {
+		t.Errorf("event = %+v, want body and time", event)
+	}
+	if event := <-chat.Events; len(event.Body) > 0 {
+		t.Errorf("event = %+v, want body only for subscribers that want it", event)
+	}
+
+	bus.Unsubscribe(all)
+	if !bus.HasSubscribers() || bus.WantsBodies() {
+		t.Error("subscriber wanting bodies not removed")
+	}
+}
+
+func TestEventBusDropsEventsOfSlowSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	subscriber := bus.Subscribe(EventFilter{})
+
+	for range eventSubscriberBuffer + 3 {
+		bus.Publish(ProxyEvent{Type: EventDone})
+	}
+
+	if dropped := subscriber.Dropped(); dropped != 3 {
+		t.Errorf("dropped = %d, want 3", dropped)
+	}
+}
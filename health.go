@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	healthPass = "pass"
+	healthFail = "fail"
+)
+
+// HealthCheck is the result of a single check of a health endpoint
+type HealthCheck struct {
+	Name    string `json:"name"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// healthReport is the body of the health endpoints
+type healthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// ReadinessCriteria configures when the proxy is ready to serve requests, the upstream must always be reachable
+type ReadinessCriteria struct {
+	// RequirePreload requires the preloading of models to be done
+	RequirePreload bool
+	// LoadedModels must be loaded into memory by the upstream
+	LoadedModels []string
+}
+
+// SetReadinessCriteria will set the criteria of the readiness endpoint
+func (s *ServerHandler) SetReadinessCriteria(criteria ReadinessCriteria) {
+	s.readiness = criteria
+	if len(criteria.LoadedModels) > 0 {
+		slog.Info(fmt.Sprintf("Ready once models are loaded: %s", strings.Join(criteria.LoadedModels, ", ")))
+	}
+}
+
+// SetHealthProbesAuth will set whether the readiness and startup probes need authorization,
+// the liveness probe never does.
+func (s *ServerHandler) SetHealthProbesAuth(enabled bool) {
+	s.healthProbesAuth = enabled
+	if enabled {
+		slog.Info("Readiness and startup probes need authorization")
+	}
+}
+
+// ServeHttpLivez will be called by the http server to check if the proxy process is alive
+func (s *ServerHandler) ServeHttpLivez(w http.ResponseWriter, r *http.Request) {
+	s.serveHealthChecks(w, r, "Liveness", false, func(ctx context.Context) []HealthCheck {
+		return []HealthCheck{{Name: "process", Status: healthPass}}
+	})
+}
+
+// ServeHttpStartupz will be called by the http server to check if the proxy finished starting,
+// i.e. the upstream was reachable and the preloading of models is done.
+func (s *ServerHandler) ServeHttpStartupz(w http.ResponseWriter, r *http.Request) {
+	s.serveHealthChecks(w, r, "Startup", s.healthProbesAuth, func(ctx context.Context) []HealthCheck {
+		upstream := HealthCheck{Name: "upstream", Status: healthPass}
+		if s.health.LastSuccess().IsZero() {
+			upstream.Status = healthFail
+			upstream.Message = "Upstream wasn't reachable yet"
+		}
+		// failed preloads don't delay the startup, they are subject to the readiness
+		return []HealthCheck{upstream, s.checkPreload(PreloadFailureIgnore)}
+	})
+}
+
+// ServeHttpReadyz will be called by the http server to check if the proxy is ready to serve requests
+func (s *ServerHandler) ServeHttpReadyz(w http.ResponseWriter, r *http.Request) {
+	s.serveHealthChecks(w, r, "Readiness", s.healthProbesAuth, func(ctx context.Context) []HealthCheck {
+		checks := []HealthCheck{s.checkUpstream()}
+		if s.readiness.RequirePreload {
+			checks = append(checks, s.checkPreload(s.preloadFailurePolicy))
+		}
+		if len(s.readiness.LoadedModels) > 0 {
+			checks = append(checks, s.checkLoadedModels())
+		}
+		return checks
+	})
+}
+
+// serveHealthChecks replies with the result of the checks, with status 503 when any check failed
+func (s *ServerHandler) serveHealthChecks(w http.ResponseWriter, r *http.Request, probe string, authenticate bool, check func(ctx context.Context) []HealthCheck) {
+	if authenticate {
+		credential := s.credentials.Take(r)
+		if _, ok := s.authRequestHandle(w, r, credential); !ok {
+			slog.Error(fmt.Sprintf("Unauthorized %s probe", strings.ToLower(probe)), "client", r.RemoteAddr)
+			return
+		}
+	}
+	report := healthReport{Status: healthPass, Checks: check(r.Context())}
+	status := http.StatusOK
+	for _, c := range report.Checks {
+		if c.Status != healthPass {
+			report.Status = healthFail
+			status = http.StatusServiceUnavailable
+		}
+	}
+	if status != http.StatusOK {
+		slog.Warn(fmt.Sprintf("%s probe failed", probe), "checks", report.Checks)
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJson(w, status, report)
+}
+
+// checkUpstream checks if the upstream is reachable
+func (s *ServerHandler) checkUpstream() HealthCheck {
+	if !s.isUpstreamRunning() {
+		message := "Upstream is unavailable"
+		if status := s.health.Status(); len(status.Error) > 0 {
+			message = fmt.Sprintf("%s: %s", message, status.Error)
+		}
+		return HealthCheck{Name: "upstream", Status: healthFail, Message: message}
+	}
+	return HealthCheck{Name: "upstream", Status: healthPass}
+}
+
+// checkPreload checks if the preloading of models is done, the policy decides whether failed models fail the check
+func (s *ServerHandler) checkPreload(policy PreloadFailurePolicy) HealthCheck {
+	check := HealthCheck{Name: "preload", Status: healthFail}
+	switch s.preloads.Status() {
+	case Unknown:
+		check.Message = "Model preload not started yet"
+	case InProgress:
+		check.Message = "Model preload in progress"
+	case Preloaded:
+		check.Status = healthPass
+		if failed := s.failedPreloads(); len(failed) > 0 {
+			check.Message = fmt.Sprintf("Failed to preload models: %s", strings.Join(failed, ", "))
+			if policy.Unready(len(failed), len(s.preloadModels)) {
+				check.Status = healthFail
+			}
+		}
+	}
+	return check
+}
+
+// failedPreloads returns the models that failed to preload
+func (s *ServerHandler) failedPreloads() []string {
+	failed := make([]string, 0)
+	for _, preload := range s.preloads.Models() {
+		if preload.State == PreloadFailed {
+			failed = append(failed, preload.Model)
+		}
+	}
+	return failed
+}
+
+// checkLoadedModels checks if the models of the readiness criteria are loaded into memory by the upstream,
+// using the models listed by the latest probe of the health checker.
+func (s *ServerHandler) checkLoadedModels() HealthCheck {
+	check := HealthCheck{Name: "loaded_models", Status: healthFail}
+	loaded, err := s.health.LoadedModels()
+	if err != nil {
+		check.Message = fmt.Sprintf("Failed to list loaded models: %s", err)
+		return check
+	}
+	missing := make([]string, 0)
+	for _, model := range s.readiness.LoadedModels {
+		// a model without tag means the "latest" tag
+		if !slices.Contains(loaded, model) && (strings.Contains(model, ":") || !slices.Contains(loaded, model+":latest")) {
+			missing = append(missing, model)
+		}
+	}
+	if len(missing) > 0 {
+		check.Message = fmt.Sprintf("Models not loaded: %s", strings.Join(missing, ", "))
+		return check
+	}
+	check.Status = healthPass
+	return check
+}
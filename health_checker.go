@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// HealthProbe is the result of a single probe of the upstream
+type HealthProbe struct {
+	Time      time.Time `json:"time"`
+	Healthy   bool      `json:"healthy"`
+	LatencyMs int64     `json:"latency_ms"`
+	Version   string    `json:"version,omitempty"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// HealthStatus is a snapshot of the upstream health
+type HealthStatus struct {
+	Healthy             bool          `json:"healthy"`
+	Version             string        `json:"version,omitempty"`
+	Error               string        `json:"error,omitempty"`
+	LastProbe           *time.Time    `json:"last_probe,omitempty"`
+	LastSuccess         *time.Time    `json:"last_success,omitempty"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	History             []HealthProbe `json:"history"`
+}
+
+// HealthChecker probes the upstream in the background and keeps the latest results,
+// so that health endpoints don't need to contact the upstream on every request.
+type HealthChecker struct {
+	versionURL  string
+	psURL       string
+	client      *http.Client
+	interval    time.Duration
+	historySize int
+	listLoaded  bool
+
+	mutex               sync.RWMutex
+	history             []HealthProbe
+	lastSuccess         time.Time
+	version             string
+	consecutiveFailures int
+	loadedModels        []string
+	loadedModelsErr     error
+}
+
+// NewHealthChecker will create a new checker probing "/api/version" of the upstream,
+// keeping the given number of probes as history.
+func NewHealthChecker(upstreamURL *url.URL, interval time.Duration, timeout time.Duration, historySize int) *HealthChecker {
+	return &HealthChecker{
+		versionURL:  upstreamURL.JoinPath("/api/version").String(),
+		psURL:       upstreamURL.JoinPath("/api/ps").String(),
+		client:      &http.Client{Timeout: timeout},
+		interval:    interval,
+		historySize: max(historySize, 1),
+	}
+}
+
+// ListLoadedModels will make each probe list the models loaded by the upstream ( "/api/ps" ) too,
+// it must be called before running the checker.
+func (c *HealthChecker) ListLoadedModels() {
+	c.listLoaded = true
+	c.loadedModelsErr = errors.New("loaded models weren't listed yet")
+}
+
+// Run probes the upstream immediately and then in the configured interval until the context is done
+func (c *HealthChecker) Run(ctx context.Context) {
+	slog.Info(fmt.Sprintf("Checking health of upstream every %s", c.interval))
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		c.Probe(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Probe checks the upstream once and records the result
+func (c *HealthChecker) Probe(ctx context.Context) HealthProbe {
+	probe := HealthProbe{Time: time.Now()}
+	version, err := c.requestVersion(ctx)
+	probe.LatencyMs = time.Since(probe.Time).Milliseconds()
+	if err != nil {
+		probe.Error = err.Error()
+	} else {
+		probe.Healthy = true
+		probe.Version = version
+	}
+	c.record(probe)
+	if c.listLoaded {
+		c.recordLoadedModels(c.requestLoadedModels(ctx))
+	}
+	return probe
+}
+
+// requestVersion requests the version of the upstream
+func (c *HealthChecker) requestVersion(ctx context.Context) (string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.versionURL, nil)
+	if err != nil {
+		return "", err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return "", fmt.Errorf("status %d", response.StatusCode)
+	}
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("invalid version response: %w", err)
+	}
+	return version.Version, nil
+}
+
+// requestLoadedModels requests the names of the models loaded by the upstream
+func (c *HealthChecker) requestLoadedModels(ctx context.Context) ([]string, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.psURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := c.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("status %d", response.StatusCode)
+	}
+	var running api.ProcessResponse
+	if err := json.NewDecoder(response.Body).Decode(&running); err != nil {
+		return nil, fmt.Errorf("invalid ps response: %w", err)
+	}
+	models := make([]string, 0, len(running.Models))
+	for _, model := range running.Models {
+		models = append(models, model.Name)
+	}
+	return models, nil
+}
+
+// recordLoadedModels keeps the latest list of loaded models
+func (c *HealthChecker) recordLoadedModels(models []string, err error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.loadedModels = models
+	c.loadedModelsErr = err
+}
+
+// record adds the probe to the history and logs changes of the health
+func (c *HealthChecker) record(probe HealthProbe) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	wasHealthy := len(c.history) > 0 && c.history[len(c.history)-1].Healthy
+	if len(c.history) >= c.historySize {
+		c.history = append(c.history[:0], c.history[len(c.history)-c.historySize+1:]...)
+	}
+	c.history = append(c.history, probe)
+	if probe.Healthy {
+		c.lastSuccess = probe.Time
+		c.version = probe.Version
+		c.consecutiveFailures = 0
+		if !wasHealthy {
+			slog.Info("Upstream is available", "version", probe.Version)
+		}
+	} else {
+		c.consecutiveFailures++
+		if wasHealthy || len(c.history) == 1 {
+			slog.Warn("Upstream is unavailable", "error", probe.Error)
+		}
+	}
+}
+
+// Healthy returns whether the latest probe succeeded
+func (c *HealthChecker) Healthy() bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.history) > 0 && c.history[len(c.history)-1].Healthy
+}
+
+// LastSuccess returns the time of the latest successful probe, zero if there was none
+func (c *HealthChecker) LastSuccess() time.Time {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.lastSuccess
+}
+
+// LoadedModels returns the models loaded by the upstream at the latest probe
+func (c *HealthChecker) LoadedModels() ([]string, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.loadedModels, c.loadedModelsErr
+}
+
+// Status returns a snapshot of the upstream health
+func (c *HealthChecker) Status() HealthStatus {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	status := HealthStatus{
+		Version:             c.version,
+		ConsecutiveFailures: c.consecutiveFailures,
+		History:             make([]HealthProbe, len(c.history)),
+	}
+	copy(status.History, c.history)
+	if len(c.history) > 0 {
+		latest := c.history[len(c.history)-1]
+		status.Healthy = latest.Healthy
+		status.Error = latest.Error
+		status.LastProbe = &latest.Time
+	}
+	if !c.lastSuccess.IsZero() {
+		lastSuccess := c.lastSuccess
+		status.LastSuccess = &lastSuccess
+	}
+	return status
+}
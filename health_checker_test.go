@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHealthCheckerRecordsProbes(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"version":"0.9.0"}`))
+	}))
+	t.Cleanup(server.Close)
+	upstreamURL, _ := url.Parse(server.URL)
+	checker := NewHealthChecker(upstreamURL, time.Minute, time.Second, 2)
+
+	checker.Probe(t.Context())
+	checker.Probe(t.Context())
+	if status := checker.Status(); status.Healthy || status.ConsecutiveFailures != 2 || status.LastSuccess != nil {
+		t.Errorf("status = %+v, want 2 failures", status)
+	}
+
+	healthy.Store(true)
+	checker.Probe(t.Context())
+	status := checker.Status()
+	if !checker.Healthy() || status.Version != "0.9.0" || status.ConsecutiveFailures != 0 || checker.LastSuccess().IsZero() {
+		t.Errorf("status = %+v, want healthy upstream", status)
+	}
+	if len(status.History) != 2 || status.History[0].Healthy || !status.History[1].Healthy {
+		t.Errorf("history = %+v, want latest 2 probes", status.History)
+	}
+}
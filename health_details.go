@@ -0,0 +1,112 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"time"
+)
+
+// healthDetails is a detailed report of what the proxy and its upstream are doing
+type healthDetails struct {
+	Hostname      string                `json:"hostname,omitempty"`
+	StartedAt     time.Time             `json:"started_at"`
+	UptimeSeconds int64                 `json:"uptime_seconds"`
+	InFlight      int                   `json:"in_flight"`
+	Upstream      healthDetailsUpstream `json:"upstream"`
+	Models        []healthDetailsModel  `json:"models"`
+	ModelsError   string                `json:"models_error,omitempty"`
+	Loaded        []healthDetailsLoaded `json:"loaded"`
+	LoadedError   string                `json:"loaded_error,omitempty"`
+	VramBytes     int64                 `json:"vram_bytes"`
+	Preload       preloadStatus         `json:"preload"`
+}
+
+type healthDetailsUpstream struct {
+	URL                 string     `json:"url"`
+	Healthy             bool       `json:"healthy"`
+	Version             string     `json:"version,omitempty"`
+	Error               string     `json:"error,omitempty"`
+	LastSuccess         *time.Time `json:"last_success,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+}
+
+// healthDetailsModel is a model present at the upstream
+type healthDetailsModel struct {
+	Name       string    `json:"name"`
+	Digest     string    `json:"digest"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// healthDetailsLoaded is a model loaded into memory by the upstream
+type healthDetailsLoaded struct {
+	Name      string    `json:"name"`
+	Digest    string    `json:"digest"`
+	Size      int64     `json:"size"`
+	SizeVram  int64     `json:"size_vram"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ServeHttpHealthDetails will be called by the http server to report version, models and memory usage of the upstream,
+// the preload status per model, in-flight requests and uptime of the proxy.
+func (s *ServerHandler) ServeHttpHealthDetails(w http.ResponseWriter, r *http.Request) {
+	credential := s.credentials.Take(r)
+	if _, ok := s.authRequestHandle(w, r, credential); !ok {
+		return
+	}
+	health := s.health.Status()
+	details := healthDetails{
+		StartedAt:     s.startedAt,
+		UptimeSeconds: int64(time.Since(s.startedAt).Seconds()),
+		InFlight:      s.requests.Len(),
+		Upstream: healthDetailsUpstream{
+			URL:                 s.upstreamBaseURL.String(),
+			Healthy:             health.Healthy,
+			Version:             health.Version,
+			Error:               health.Error,
+			LastSuccess:         health.LastSuccess,
+			ConsecutiveFailures: health.ConsecutiveFailures,
+		},
+		Models: make([]healthDetailsModel, 0),
+		Loaded: make([]healthDetailsLoaded, 0),
+		Preload: preloadStatus{
+			Status:   s.preloads.Status().String(),
+			Progress: s.preloads.Overall(),
+			Models:   s.preloads.Models(),
+			Pulls:    s.pulls.Pulls(),
+		},
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		details.Hostname = hostname
+	}
+
+	client := s.upstreamClient()
+	if tags, err := client.List(r.Context()); err != nil {
+		details.ModelsError = err.Error()
+	} else {
+		for _, model := range tags.Models {
+			details.Models = append(details.Models, healthDetailsModel{
+				Name:       model.Name,
+				Digest:     model.Digest,
+				Size:       model.Size,
+				ModifiedAt: model.ModifiedAt,
+			})
+		}
+	}
+	if running, err := client.ListRunning(r.Context()); err != nil {
+		details.LoadedError = err.Error()
+	} else {
+		for _, model := range running.Models {
+			details.VramBytes += model.SizeVRAM
+			details.Loaded = append(details.Loaded, healthDetailsLoaded{
+				Name:      model.Name,
+				Digest:    model.Digest,
+				Size:      model.Size,
+				SizeVram:  model.SizeVRAM,
+				ExpiresAt: model.ExpiresAt,
+			})
+		}
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJson(w, http.StatusOK, details)
+}
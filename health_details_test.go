@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealthDetails(t *testing.T) {
+	s, checker := newTestHealthServerHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			w.Write([]byte(`{"version":"0.9.0"}`))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[{"name":"qwen3:8b","digest":"abc","size":5}]}`))
+		case "/api/ps":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	checker.Probe(t.Context())
+
+	r := httptest.NewRequest(http.MethodGet, "/health/details", nil)
+	r.Header.Set("Authorization", "Bearer valid-key")
+	w := httptest.NewRecorder()
+	s.ServeHttpHealthDetails(w, r)
+
+	var details healthDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatalf("status %d, body %q: %s", w.Code, w.Body, err)
+	}
+	if !details.Upstream.Healthy || details.Upstream.Version != "0.9.0" {
+		t.Errorf("upstream = %+v, want healthy upstream with version", details.Upstream)
+	}
+	if len(details.Models) != 1 || details.Models[0].Name != "qwen3:8b" || details.Models[0].Digest != "abc" {
+		t.Errorf("models = %+v, want models of upstream", details.Models)
+	}
+	if len(details.LoadedError) == 0 || len(details.Loaded) != 0 {
+		t.Errorf("loaded = %+v (%s), want error of listing loaded models", details.Loaded, details.LoadedError)
+	}
+}
+
+func TestHealthDetailsSumsVram(t *testing.T) {
+	s, _ := newTestHealthServerHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/ps":
+			w.Write([]byte(`{"models":[{"name":"qwen3:8b","size_vram":3},{"name":"gemma3:4b","size_vram":4}]}`))
+		}
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/health/details", nil)
+	r.Header.Set("Authorization", "Bearer valid-key")
+	w := httptest.NewRecorder()
+	s.ServeHttpHealthDetails(w, r)
+
+	var details healthDetails
+	if err := json.Unmarshal(w.Body.Bytes(), &details); err != nil {
+		t.Fatal(err)
+	}
+	if len(details.Loaded) != 2 || details.VramBytes != 7 {
+		t.Errorf("loaded = %+v, VRAM = %d, want 2 models using 7 bytes", details.Loaded, details.VramBytes)
+	}
+}
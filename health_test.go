@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestHealthServerHandler creates a server handler whose upstream serves the given handler
+func newTestHealthServerHandler(t *testing.T, upstream http.Handler) (*ServerHandler, *HealthChecker) {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	upstreamURL, _ := url.Parse(server.URL)
+	s := newTestServerHandler(t, &ApiKey{Name: "key-1", Key: "valid-key"})
+	s.SetUpstreamURL(upstreamURL)
+	checker := NewHealthChecker(upstreamURL, time.Minute, time.Second, 1)
+	s.SetHealthChecker(checker)
+	return s, checker
+}
+
+func TestHealthProbesAuth(t *testing.T) {
+	s, checker := newTestHealthServerHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"version":"0.9.0"}`))
+	}))
+	checker.Probe(t.Context())
+
+	probe := func(serve http.HandlerFunc, key string) int {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(key) > 0 {
+			r.Header.Set("Authorization", "Bearer "+key)
+		}
+		w := httptest.NewRecorder()
+		serve(w, r)
+		return w.Code
+	}
+
+	for _, serve := range []http.HandlerFunc{s.ServeHttpLivez, s.ServeHttpReadyz} {
+		if status := probe(serve, ""); status != http.StatusOK {
+			t.Errorf("status without authorization = %d, want 200", status)
+		}
+	}
+
+	s.SetHealthProbesAuth(true)
+	if status := probe(s.ServeHttpLivez, ""); status != http.StatusOK {
+		t.Errorf("status of liveness probe = %d, want 200", status)
+	}
+	if status := probe(s.ServeHttpReadyz, ""); status != http.StatusUnauthorized {
+		t.Errorf("status of readiness probe without authorization = %d, want 401", status)
+	}
+	if status := probe(s.ServeHttpReadyz, "valid-key"); status != http.StatusOK {
+		t.Errorf("status of readiness probe with authorization = %d, want 200", status)
+	}
+}
+
+func TestReadinessUsesLoadedModelsOfLatestProbe(t *testing.T) {
+	var psCalls atomic.Int32
+	var loaded atomic.Value
+	loaded.Store(`{"models":[]}`)
+	s, checker := newTestHealthServerHandler(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/ps" {
+			psCalls.Add(1)
+			w.Write([]byte(loaded.Load().(string)))
+			return
+		}
+		w.Write([]byte(`{"version":"0.9.0"}`))
+	}))
+	s.SetReadinessCriteria(ReadinessCriteria{LoadedModels: []string{"qwen3"}})
+	checker.ListLoadedModels()
+
+	ready := func() int {
+		w := httptest.NewRecorder()
+		s.ServeHttpReadyz(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		return w.Code
+	}
+
+	checker.Probe(t.Context())
+	if status := ready(); status != http.StatusServiceUnavailable {
+		t.Errorf("status without loaded model = %d, want 503", status)
+	}
+
+	loaded.Store(`{"models":[{"name":"qwen3:latest"}]}`)
+	if status := ready(); status != http.StatusServiceUnavailable {
+		t.Errorf("status before next probe = %d, want 503", status)
+	}
+	checker.Probe(t.Context())
+	if status := ready(); status != http.StatusOK {
+		t.Errorf("status with loaded model = %d, want 200", status)
+	}
+
+	if n := psCalls.Load(); n != 2 {
+		t.Errorf("loaded models listed %d times, want once per probe", n)
+	}
+}
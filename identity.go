@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AuthMethod is the way a caller got authenticated
+type AuthMethod string
+
+const (
+	AuthMethodApiKey     AuthMethod = "apikey"
+	AuthMethodClientCert AuthMethod = "mtls"
+	AuthMethodJwt        AuthMethod = "jwt"
+	AuthMethodIntrospect AuthMethod = "introspection"
+)
+
+// Scopes restrict the routes an identity may use, an identity without scopes may use every route.
+// Identities of tokens always have at least one scope.
+const (
+	ScopeChat        = "chat"
+	ScopeEmbed       = "embed"
+	ScopeModelsRead  = "models:read"
+	ScopeModelsWrite = "models:write"
+)
+
+// Identity is the authenticated caller of a request
+type Identity struct {
+	Name          string
+	AuthMethod    AuthMethod
+	UserId        string
+	UserName      string
+	Scopes        []string
+	AllowedModels []string
+	// Key holds the per-key settings of the identity, nil if the identity isn't backed by a key
+	Key *ApiKey
+}
+
+// NewKeyIdentity will create the identity of a key of the key store
+func NewKeyIdentity(key *ApiKey, method AuthMethod) *Identity {
+	return &Identity{
+		Name:          key.Name,
+		AuthMethod:    method,
+		Scopes:        key.Scopes,
+		AllowedModels: key.AllowedModels,
+		Key:           key,
+	}
+}
+
+// HasScope checks if the identity may use routes of the given scope
+func (i *Identity) HasScope(scope string) bool {
+	return len(i.Scopes) == 0 || slices.Contains(i.Scopes, scope)
+}
+
+// IsModelAllowed checks if the identity may use the given model, the allowlist supports glob patterns
+func (i *Identity) IsModelAllowed(model string) bool {
+	if len(i.AllowedModels) == 0 {
+		return true
+	}
+	for _, allowed := range i.AllowedModels {
+		if allowed == model {
+			return true
+		}
+		if matched, _ := path.Match(allowed, model); matched {
+			return true
+		}
+		// a model without tag means the "latest" tag
+		if !strings.Contains(model, ":") && allowed == model+":latest" {
+			return true
+		}
+	}
+	return false
+}
+
+// knownScopes returns the scopes known by the proxy, other scopes of an identity provider don't restrict anything
+func knownScopes(scopes []string) []string {
+	return slices.DeleteFunc(slices.Clone(scopes), func(scope string) bool {
+		return !slices.Contains([]string{ScopeChat, ScopeEmbed, ScopeModelsRead, ScopeModelsWrite}, scope)
+	})
+}
+
+// checkScopes returns an error for the first scope that isn't known by the proxy
+func checkScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if len(knownScopes([]string{scope})) == 0 {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// requiredScope returns the scope needed to use the given route
+func requiredScope(method string, route string) string {
+	switch route {
+	case "/api/generate", "/api/chat", "/v1/chat/completions", "/v1/completions", "/v1/responses":
+		return ScopeChat
+	case "/api/embed", "/api/embeddings", "/v1/embeddings":
+		return ScopeEmbed
+	case "/", "/api/tags", "/api/ps", "/api/version", "/api/show", "/v1/models":
+		return ScopeModelsRead
+	}
+	if method == http.MethodGet && strings.HasPrefix(route, "/v1/models/") {
+		return ScopeModelsRead
+	}
+	return ScopeModelsWrite
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// introspectionCacheSize is the number of cached results that triggers removal of expired results
+const introspectionCacheSize = 10000
+
+// IntrospectionResult is the relevant part of a RFC 7662 introspection response
+type IntrospectionResult struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope"`
+	Username  string   `json:"username"`
+	Subject   string   `json:"sub"`
+	ClientId  string   `json:"client_id"`
+	ExpiresAt *float64 `json:"exp"`
+}
+
+// introspectionCacheEntry is a cached introspection result
+type introspectionCacheEntry struct {
+	result    *IntrospectionResult
+	expiresAt time.Time
+}
+
+// TokenIntrospector validates opaque bearer tokens with a RFC 7662 introspection endpoint
+type TokenIntrospector struct {
+	url          string
+	clientId     string
+	clientSecret string
+	positiveTTL  time.Duration
+	negativeTTL  time.Duration
+	// defaultScopes are granted to a token without any known scope
+	defaultScopes []string
+	client        *http.Client
+
+	mutex sync.Mutex
+	cache map[[32]byte]introspectionCacheEntry
+	// rateLimit is the max number of calls to the introspection endpoint per second, zero means unlimited.
+	// Calls take from a bucket of up to rateLimit tokens, refilled continuously.
+	rateLimit  float64
+	rateTokens float64
+	rateRefill time.Time
+}
+
+// ErrIntrospectionRateLimited is returned when a token can't be introspected due to the rate limit
+var ErrIntrospectionRateLimited = errors.New("introspection rate limit exceeded")
+
+// NewTokenIntrospector will create a new introspector using given client credentials,
+// active results are cached for positiveTTL and inactive results for negativeTTL.
+// A token without any known scope gets the default scopes, without default scopes such a token is rejected.
+func NewTokenIntrospector(url string, clientId string, clientSecret string, timeout time.Duration, positiveTTL time.Duration, negativeTTL time.Duration, defaultScopes []string) (*TokenIntrospector, error) {
+	if err := checkScopes(defaultScopes); err != nil {
+		return nil, fmt.Errorf("invalid default introspection scopes: %w", err)
+	}
+	return &TokenIntrospector{
+		url:           url,
+		clientId:      clientId,
+		clientSecret:  clientSecret,
+		positiveTTL:   positiveTTL,
+		negativeTTL:   negativeTTL,
+		defaultScopes: defaultScopes,
+		client:        &http.Client{Timeout: timeout},
+		cache:         make(map[[32]byte]introspectionCacheEntry),
+	}, nil
+}
+
+// SetRateLimit will limit the calls to the introspection endpoint to the given number per second,
+// so that random tokens can't flood the endpoint.
+func (t *TokenIntrospector) SetRateLimit(callsPerSecond float64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.rateLimit = callsPerSecond
+	t.rateTokens = callsPerSecond
+	t.rateRefill = time.Now()
+}
+
+// takeRateToken checks if the rate limit allows another call to the introspection endpoint
+func (t *TokenIntrospector) takeRateToken(now time.Time) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.rateLimit <= 0 {
+		return true
+	}
+	t.rateTokens = min(t.rateTokens+now.Sub(t.rateRefill).Seconds()*t.rateLimit, t.rateLimit)
+	t.rateRefill = now
+	if t.rateTokens < 1 {
+		return false
+	}
+	t.rateTokens--
+	return true
+}
+
+// Introspect returns the (cached) introspection result of the given token
+func (t *TokenIntrospector) Introspect(ctx context.Context, token string) (*IntrospectionResult, error) {
+	// only keep a hash of the token in memory
+	key := sha256.Sum256([]byte(token))
+	now := time.Now()
+
+	t.mutex.Lock()
+	entry, found := t.cache[key]
+	t.mutex.Unlock()
+	if found && now.Before(entry.expiresAt) {
+		return entry.result, nil
+	}
+	if !t.takeRateToken(now) {
+		return nil, ErrIntrospectionRateLimited
+	}
+
+	result, err := t.request(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	expiresAt := now.Add(t.negativeTTL)
+	if result.Active {
+		expiresAt = now.Add(t.positiveTTL)
+		if result.ExpiresAt != nil {
+			if tokenExpiry := time.Unix(int64(*result.ExpiresAt), 0); tokenExpiry.Before(expiresAt) {
+				expiresAt = tokenExpiry
+			}
+		}
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if len(t.cache) >= introspectionCacheSize {
+		for k, e := range t.cache {
+			if now.After(e.expiresAt) {
+				delete(t.cache, k)
+			}
+		}
+	}
+	if len(t.cache) < introspectionCacheSize {
+		t.cache[key] = introspectionCacheEntry{result: result, expiresAt: expiresAt}
+	}
+	return result, nil
+}
+
+// request calls the introspection endpoint for the given token
+func (t *TokenIntrospector) request(ctx context.Context, token string) (*IntrospectionResult, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(t.clientId) > 0 {
+		req.SetBasicAuth(url.QueryEscape(t.clientId), url.QueryEscape(t.clientSecret))
+	}
+	response, err := t.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to introspect token: %w", err)
+	}
+	defer response.Body.Close()
+	if (response.StatusCode / 100) != 2 {
+		return nil, fmt.Errorf("token introspection failed with status %d", response.StatusCode)
+	}
+	var result IntrospectionResult
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to parse introspection response: %w", err)
+	}
+	if result.ExpiresAt != nil && time.Now().After(time.Unix(int64(*result.ExpiresAt), 0)) {
+		result.Active = false
+	}
+	return &result, nil
+}
+
+// Identity maps an active introspection result to the identity of the caller,
+// fails when the token has no known scope and there are no default scopes.
+func (t *TokenIntrospector) Identity(result *IntrospectionResult) (*Identity, error) {
+	identity := &Identity{
+		Name:       result.Username,
+		AuthMethod: AuthMethodIntrospect,
+		UserId:     result.Subject,
+		UserName:   result.Username,
+		Scopes:     knownScopes(strings.Fields(result.Scope)),
+	}
+	if len(identity.Scopes) == 0 {
+		identity.Scopes = t.defaultScopes
+	}
+	if len(identity.Scopes) == 0 {
+		return nil, errors.New("token without known scope")
+	}
+	if len(identity.UserId) == 0 {
+		identity.UserId = result.Username
+	}
+	if len(identity.Name) == 0 {
+		identity.Name = identity.UserId
+	}
+	if len(identity.Name) == 0 {
+		identity.Name = result.ClientId
+	}
+	return identity, nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestIntrospectionServer creates an introspection endpoint that knows the given active tokens and their scope
+func newTestIntrospectionServer(t *testing.T, tokens map[string]string, calls *atomic.Int32) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		if clientId, clientSecret, ok := r.BasicAuth(); !ok || clientId != "proxy" || clientSecret != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		scope, active := tokens[r.PostForm.Get("token")]
+		json.NewEncoder(w).Encode(map[string]any{
+			"active":   active,
+			"scope":    scope,
+			"sub":      "user-1",
+			"username": "jane",
+			"exp":      time.Now().Add(time.Hour).Unix(),
+		})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestTokenIntrospectorCachesResults(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, map[string]string{"active-token": "chat"}, &calls)
+	introspector, err := NewTokenIntrospector(server.URL, "proxy", "secret", time.Second, time.Minute, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for range 2 {
+		result, err := introspector.Introspect(context.Background(), "active-token")
+		if err != nil || !result.Active {
+			t.Fatalf("result = %+v, err = %v, want active token", result, err)
+		}
+		result, err = introspector.Introspect(context.Background(), "unknown-token")
+		if err != nil || result.Active {
+			t.Fatalf("result = %+v, err = %v, want inactive token", result, err)
+		}
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("introspection calls = %d, want 2", n)
+	}
+}
+
+func TestTokenIntrospectorReportsFailures(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, nil, &calls)
+	introspector, err := NewTokenIntrospector(server.URL, "proxy", "wrong", time.Second, time.Minute, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := introspector.Introspect(context.Background(), "token"); err == nil {
+		t.Error("failed introspection not reported")
+	}
+}
+
+func TestTokenIntrospectorIdentityScopes(t *testing.T) {
+	introspector, _ := NewTokenIntrospector("http://localhost", "", "", time.Second, 0, 0, nil)
+
+	identity, err := introspector.Identity(&IntrospectionResult{Active: true, Subject: "user-1", Scope: "openid embed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(identity.Scopes, []string{ScopeEmbed}) || identity.HasScope(ScopeModelsWrite) {
+		t.Errorf("scopes = %v, want embed only", identity.Scopes)
+	}
+
+	if identity, err := introspector.Identity(&IntrospectionResult{Active: true, Subject: "user-1", Scope: "openid"}); err == nil {
+		t.Errorf("identity = %+v, want token without known scope rejected", identity)
+	}
+
+	withDefaults, _ := NewTokenIntrospector("http://localhost", "", "", time.Second, 0, 0, []string{ScopeChat})
+	identity, err = withDefaults.Identity(&IntrospectionResult{Active: true, ClientId: "service"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "service" || !slices.Equal(identity.Scopes, []string{ScopeChat}) {
+		t.Errorf("identity = %+v, want client with default scopes", identity)
+	}
+}
+
+func TestAuthenticateIntrospectedToken(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, map[string]string{"chat-token": "chat", "other-token": "profile"}, &calls)
+	introspector, err := NewTokenIntrospector(server.URL, "proxy", "secret", time.Second, time.Minute, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServerHandler(t)
+	s.SetTokenIntrospector(introspector)
+
+	tests := []struct {
+		token  string
+		status int
+	}{
+		{"chat-token", http.StatusOK},
+		{"other-token", http.StatusForbidden},
+		{"unknown-token", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		r.Header.Set("Authorization", "Bearer "+tt.token)
+		identity, w := authenticate(s, r)
+		if w.Code != tt.status || (identity != nil) != (tt.status == http.StatusOK) {
+			t.Errorf("%s: identity = %+v, status %d, want %d", tt.token, identity, w.Code, tt.status)
+		}
+	}
+}
+
+func TestAuthenticateLimitsIntrospectionCalls(t *testing.T) {
+	var calls atomic.Int32
+	server := newTestIntrospectionServer(t, map[string]string{"chat-token": "chat"}, &calls)
+	introspector, err := NewTokenIntrospector(server.URL, "proxy", "secret", time.Second, time.Minute, time.Minute, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	introspector.SetRateLimit(2)
+	s := newTestServerHandler(t)
+	s.SetTokenIntrospector(introspector)
+
+	statuses := make([]int, 0)
+	for _, token := range []string{"chat-token", "random-1", "random-2", "random-3", "chat-token"} {
+		r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		_, w := authenticate(s, r)
+		statuses = append(statuses, w.Code)
+	}
+
+	want := []int{http.StatusOK, http.StatusUnauthorized, http.StatusTooManyRequests, http.StatusTooManyRequests, http.StatusOK}
+	if !slices.Equal(statuses, want) || calls.Load() != 2 {
+		t.Errorf("statuses = %v, calls = %d, want %v with cached token still accepted", statuses, calls.Load(), want)
+	}
+}
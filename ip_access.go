@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPRules allow or deny client IPs by CIDR, deny rules take precedence over allow rules
+type IPRules struct {
+	allow []netip.Prefix
+	deny  []netip.Prefix
+}
+
+// NewIPRules will create new rules from the given CIDRs, a plain IP is handled like a single host CIDR
+func NewIPRules(allow []string, deny []string) (*IPRules, error) {
+	allowPrefixes, err := parseCidrs(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyPrefixes, err := parseCidrs(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &IPRules{allow: allowPrefixes, deny: denyPrefixes}, nil
+}
+
+// IsEmpty checks if there are no rules at all
+func (r *IPRules) IsEmpty() bool {
+	return r == nil || (len(r.allow) == 0 && len(r.deny) == 0)
+}
+
+// Check returns whether the given IP is allowed and otherwise the rule that rejected it.
+// Without any allow rule every IP that isn't denied is allowed.
+func (r *IPRules) Check(ip netip.Addr) (bool, string) {
+	if allowed, reason := r.CheckDeny(ip); !allowed {
+		return false, reason
+	}
+	return r.CheckAllow(ip)
+}
+
+// CheckDeny returns whether the given IP passes the deny rules and otherwise the rule that rejected it
+func (r *IPRules) CheckDeny(ip netip.Addr) (bool, string) {
+	if r == nil || len(r.deny) == 0 {
+		return true, ""
+	}
+	if !ip.IsValid() {
+		return false, "unknown client IP"
+	}
+	ip = ip.Unmap()
+	for _, prefix := range r.deny {
+		if prefix.Contains(ip) {
+			return false, fmt.Sprintf("denied by %s", prefix)
+		}
+	}
+	return true, ""
+}
+
+// CheckAllow returns whether the given IP passes the allow rules, every IP passes without allow rules
+func (r *IPRules) CheckAllow(ip netip.Addr) (bool, string) {
+	if r == nil || len(r.allow) == 0 {
+		return true, ""
+	}
+	if !ip.IsValid() {
+		return false, "unknown client IP"
+	}
+	ip = ip.Unmap()
+	for _, prefix := range r.allow {
+		if prefix.Contains(ip) {
+			return true, ""
+		}
+	}
+	return false, "not in allowlist"
+}
+
+// ClientIPResolver derives the IP of the client of a request,
+// X-Forwarded-For is only trusted when the peer is a trusted proxy.
+type ClientIPResolver struct {
+	trustedProxies []netip.Prefix
+}
+
+// NewClientIPResolver will create a new resolver trusting the proxies of the given CIDRs
+func NewClientIPResolver(trustedProxies []string) (*ClientIPResolver, error) {
+	prefixes, err := parseCidrs(trustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	return &ClientIPResolver{trustedProxies: prefixes}, nil
+}
+
+// ClientIP returns the IP of the client of the request. The X-Forwarded-For chain is walked from the
+// right, skipping trusted proxies, the first untrusted address is the client.
+func (c *ClientIPResolver) ClientIP(r *http.Request) netip.Addr {
+	peer := parseRemoteAddr(r.RemoteAddr)
+	if c == nil || !c.isTrusted(peer) {
+		return peer
+	}
+	client := peer
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip, err := netip.ParseAddr(strings.TrimSpace(forwarded[i]))
+		if err != nil {
+			break
+		}
+		client = ip.Unmap()
+		if !c.isTrusted(client) {
+			break
+		}
+	}
+	return client
+}
+
+func (c *ClientIPResolver) isTrusted(ip netip.Addr) bool {
+	for _, prefix := range c.trustedProxies {
+		if prefix.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRemoteAddr parses the IP of a "host:port" remote address
+func parseRemoteAddr(remoteAddr string) netip.Addr {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return netip.Addr{}
+	}
+	return ip.Unmap()
+}
+
+// parseCidrs parses CIDRs like "10.0.0.0/8" or plain IPs
+func parseCidrs(cidrs []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		cidr = strings.TrimSpace(cidr)
+		if len(cidr) == 0 {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip, err := netip.ParseAddr(cidr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", cidr)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", cidr)
+		}
+		if prefix.Addr().Is4In6() {
+			// a mapped prefix shorter than the mapping prefix ::ffff:0:0/96 can't be expressed as IPv4 prefix
+			if prefix.Bits() < 96 {
+				return nil, fmt.Errorf("invalid CIDR %q: IPv4-mapped prefix shorter than /96", cidr)
+			}
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestIPRulesCheck(t *testing.T) {
+	rules, err := NewIPRules([]string{"10.0.0.0/8", "2001:db8::/32"}, []string{"10.0.0.7"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip      string
+		allowed bool
+	}{
+		{"10.1.2.3", true},
+		{"::ffff:10.1.2.3", true},
+		{"10.0.0.7", false},
+		{"192.168.1.1", false},
+		{"2001:db8::1", true},
+	}
+	for _, tt := range tests {
+		if allowed, reason := rules.Check(netip.MustParseAddr(tt.ip)); allowed != tt.allowed {
+			t.Errorf("%s allowed = %t (%s), want %t", tt.ip, allowed, reason, tt.allowed)
+		}
+	}
+
+	denyOnly, _ := NewIPRules(nil, []string{"203.0.113.0/24"})
+	if allowed, _ := denyOnly.Check(netip.MustParseAddr("198.51.100.1")); !allowed {
+		t.Error("IP rejected without allow rules")
+	}
+	if allowed, _ := denyOnly.Check(netip.Addr{}); allowed {
+		t.Error("unknown client IP allowed by rules")
+	}
+}
+
+func TestParseCidrs(t *testing.T) {
+	prefixes, err := parseCidrs([]string{" 192.168.0.1 ", "::ffff:10.0.0.0/104", "2001:db8::/32"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"192.168.0.1/32", "10.0.0.0/8", "2001:db8::/32"}
+	for i, prefix := range prefixes {
+		if prefix.String() != want[i] {
+			t.Errorf("prefix %d = %s, want %s", i, prefix, want[i])
+		}
+	}
+
+	for _, invalid := range []string{"::ffff:0:0/80", "10.0.0.0/33", "not-an-ip"} {
+		if _, err := parseCidrs([]string{invalid}); err == nil {
+			t.Errorf("invalid CIDR %q accepted", invalid)
+		}
+	}
+}
+
+func TestClientIPResolver(t *testing.T) {
+	resolver, err := NewClientIPResolver([]string{"172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		clientIP   string
+	}{
+		{"untrusted peer", "198.51.100.1:1234", "10.0.0.1", "198.51.100.1"},
+		{"trusted peer", "172.16.0.2:1234", "10.0.0.1", "10.0.0.1"},
+		{"chain of trusted proxies", "172.16.0.2:1234", "10.0.0.1, 172.16.0.3", "10.0.0.1"},
+		{"spoofed chain", "172.16.0.2:1234", "10.0.0.1, 203.0.113.9", "203.0.113.9"},
+		{"malformed header", "172.16.0.2:1234", "unknown", "172.16.0.2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+			if ip := resolver.ClientIP(r); ip.String() != tt.clientIP {
+				t.Errorf("client IP = %s, want %s", ip, tt.clientIP)
+			}
+		})
+	}
+}
+
+func TestAuthenticateWithIPRules(t *testing.T) {
+	s := newTestServerHandler(t,
+		&ApiKey{Name: "office", Key: "office-key", AllowedCidrs: []string{"10.1.0.0/16"}},
+		&ApiKey{Name: "partner", Key: "partner-key", AllowedCidrs: []string{"198.51.100.0/24"}, OverrideGlobalCidrs: true},
+	)
+	rules, _ := NewIPRules([]string{"10.0.0.0/8"}, []string{"198.51.100.66"})
+	resolver, _ := NewClientIPResolver(nil)
+	s.SetIPRules(rules, resolver)
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		key        string
+		status     int
+	}{
+		{"key within its CIDRs", "10.1.2.3:1234", "office-key", http.StatusOK},
+		{"key outside its CIDRs", "10.2.2.3:1234", "office-key", http.StatusForbidden},
+		{"key outside global CIDRs", "198.51.100.7:1234", "office-key", http.StatusForbidden},
+		{"unknown key outside global CIDRs", "198.51.100.7:1234", "unknown-key", http.StatusForbidden},
+		{"overriding key outside global CIDRs", "198.51.100.7:1234", "partner-key", http.StatusOK},
+		{"overriding key outside its CIDRs", "10.1.2.3:1234", "partner-key", http.StatusForbidden},
+		{"overriding key from globally denied IP", "198.51.100.66:1234", "partner-key", http.StatusForbidden},
+		{"malformed credential from globally denied IP", "198.51.100.66:1234", "partner key", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("Authorization", "Bearer "+tt.key)
+			identity, w := authenticate(s, r)
+			if w.Code != tt.status || (identity != nil) != (tt.status == http.StatusOK) {
+				t.Errorf("identity = %+v, status %d, want %d", identity, w.Code, tt.status)
+			}
+		})
+	}
+}
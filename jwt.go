@@ -0,0 +1,402 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// jsonWebKey is a single key of a JWKS
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// jwtKey is a parsed verification key
+type jwtKey struct {
+	id  string
+	alg string
+	key any
+}
+
+// stringList is a JSON claim that is either a single string or a list of strings
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = strings.Fields(single)
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// JwtClaims are the claims of a validated JWT used by the proxy
+type JwtClaims struct {
+	Issuer            string     `json:"iss"`
+	Subject           string     `json:"sub"`
+	Audience          stringList `json:"aud"`
+	ExpiresAt         *float64   `json:"exp"`
+	NotBefore         *float64   `json:"nbf"`
+	Name              string     `json:"name"`
+	PreferredUsername string     `json:"preferred_username"`
+	Groups            stringList `json:"groups"`
+	Scope             stringList `json:"scope"`
+	Scp               stringList `json:"scp"`
+}
+
+// JwtValidator validates JWT bearer tokens against the keys of a JWKS file or URL
+type JwtValidator struct {
+	jwksSource  string
+	issuer      string
+	audience    string
+	leeway      time.Duration
+	groupScopes map[string][]string
+	groupModels map[string][]string
+	// defaultScopes are granted to a token without any known scope
+	defaultScopes []string
+	client        *http.Client
+
+	mutex       sync.RWMutex
+	keys        []jwtKey
+	lastRefresh time.Time
+	// lastForcedRefresh is the last attempt to refresh the JWKS for a token with unknown key id, successful or not
+	lastForcedRefresh time.Time
+}
+
+// jwksForcedRefreshInterval is the minimum interval between refreshes of the JWKS for tokens with unknown key id
+const jwksForcedRefreshInterval = time.Minute
+
+// NewJwtValidator will create a new validator and load the JWKS initially.
+// A token without any known scope gets the default scopes, without default scopes such a token is rejected.
+func NewJwtValidator(jwksSource string, issuer string, audience string, leeway time.Duration, groupScopes map[string][]string, groupModels map[string][]string, defaultScopes []string) (*JwtValidator, error) {
+	if err := checkScopes(defaultScopes); err != nil {
+		return nil, fmt.Errorf("invalid default JWT scopes: %w", err)
+	}
+	v := &JwtValidator{
+		jwksSource:    jwksSource,
+		issuer:        issuer,
+		audience:      audience,
+		leeway:        leeway,
+		groupScopes:   groupScopes,
+		groupModels:   groupModels,
+		defaultScopes: defaultScopes,
+		client:        &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := v.Refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Refresh (re)loads the keys of the JWKS
+func (v *JwtValidator) Refresh(ctx context.Context) error {
+	var data []byte
+	var err error
+	if strings.HasPrefix(v.jwksSource, "http://") || strings.HasPrefix(v.jwksSource, "https://") {
+		data, err = v.fetchJwks(ctx)
+	} else {
+		data, err = os.ReadFile(v.jwksSource)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load JWKS %s: %w", v.jwksSource, err)
+	}
+
+	var jwks struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &jwks); err != nil {
+		return fmt.Errorf("failed to parse JWKS %s: %w", v.jwksSource, err)
+	}
+	keys := make([]jwtKey, 0)
+	for _, jwk := range jwks.Keys {
+		key, err := parseJsonWebKey(jwk)
+		if err != nil {
+			slog.Error("Ignoring invalid JWKS key", "kid", jwk.Kid, "error", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	v.keys = keys
+	v.lastRefresh = time.Now()
+	slog.Info(fmt.Sprintf("Loaded %d JWKS keys from %s", len(keys), v.jwksSource))
+	return nil
+}
+
+func (v *JwtValidator) fetchJwks(ctx context.Context) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.jwksSource, nil)
+	if err != nil {
+		return nil, err
+	}
+	response, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if (response.StatusCode / 100) != 2 {
+		return nil, fmt.Errorf("JWKS request failed with status %d", response.StatusCode)
+	}
+	return io.ReadAll(response.Body)
+}
+
+// Run refreshes the JWKS in the given interval until the context is done
+func (v *JwtValidator) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := v.Refresh(ctx); err != nil {
+				slog.Error("Failed to refresh JWKS", "error", err)
+			}
+		}
+	}
+}
+
+// claimForcedRefresh checks if a token with unknown key id may refresh the JWKS. Failed refreshes count too,
+// so that neither an unavailable JWKS URL nor concurrent tokens cause a refresh per token.
+func (v *JwtValidator) claimForcedRefresh() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(v.lastRefresh) < jwksForcedRefreshInterval || now.Sub(v.lastForcedRefresh) < jwksForcedRefreshInterval {
+		return false
+	}
+	v.lastForcedRefresh = now
+	return true
+}
+
+// Validate verifies signature, issuer, audience and validity period of the token and returns its claims
+func (v *JwtValidator) Validate(token string) (*JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJwtSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %w", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %w", err)
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	keys := v.candidateKeys(header.Alg, header.Kid)
+	if len(keys) == 0 && len(header.Kid) > 0 {
+		// the key may have been rotated
+		if v.claimForcedRefresh() {
+			if err := v.Refresh(context.Background()); err != nil {
+				slog.Error("Failed to refresh JWKS", "error", err)
+			}
+			keys = v.candidateKeys(header.Alg, header.Kid)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no key for algorithm %q and key id %q", header.Alg, header.Kid)
+	}
+	verified := false
+	for _, key := range keys {
+		if verifyJwtSignature(header.Alg, key.key, signed, signature) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, errors.New("invalid signature")
+	}
+
+	var claims JwtClaims
+	if err := decodeJwtSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %w", err)
+	}
+	now := time.Now()
+	if claims.ExpiresAt == nil {
+		return nil, errors.New("token without expiry")
+	}
+	if now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(v.leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if claims.NotBefore != nil && now.Add(v.leeway).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return nil, errors.New("token not yet valid")
+	}
+	if len(v.issuer) > 0 && claims.Issuer != v.issuer {
+		return nil, fmt.Errorf("unexpected issuer %q", claims.Issuer)
+	}
+	if len(v.audience) > 0 && !slices.Contains(claims.Audience, v.audience) {
+		return nil, fmt.Errorf("unexpected audience %q", claims.Audience)
+	}
+	if len(claims.Subject) == 0 {
+		return nil, errors.New("token without subject")
+	}
+	return &claims, nil
+}
+
+// Identity maps the claims of a validated token to the identity of the caller,
+// fails when the token has no known scope and there are no default scopes.
+func (v *JwtValidator) Identity(claims *JwtClaims) (*Identity, error) {
+	identity := &Identity{
+		Name:       claims.Subject,
+		AuthMethod: AuthMethodJwt,
+		UserId:     claims.Subject,
+		UserName:   claims.Name,
+	}
+	if len(identity.UserName) == 0 {
+		identity.UserName = claims.PreferredUsername
+	}
+	scopes := append(slices.Clone(claims.Scope), claims.Scp...)
+	models := make([]string, 0)
+	for _, group := range claims.Groups {
+		scopes = append(scopes, v.groupScopes[group]...)
+		models = append(models, v.groupModels[group]...)
+	}
+	identity.Scopes = knownScopes(scopes)
+	if len(identity.Scopes) == 0 {
+		identity.Scopes = v.defaultScopes
+	}
+	if len(identity.Scopes) == 0 {
+		return nil, errors.New("token without known scope")
+	}
+	identity.AllowedModels = models
+	return identity, nil
+}
+
+// candidateKeys returns the keys that may have signed a token with given algorithm and key id
+func (v *JwtValidator) candidateKeys(alg string, kid string) []jwtKey {
+	v.mutex.RLock()
+	defer v.mutex.RUnlock()
+	keys := make([]jwtKey, 0)
+	for _, key := range v.keys {
+		if key.alg != alg {
+			continue
+		}
+		if len(kid) > 0 && key.id != kid {
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// parseJsonWebKey parses a RSA, EC P-256 or symmetric key of a JWKS
+func parseJsonWebKey(jwk jsonWebKey) (jwtKey, error) {
+	key := jwtKey{id: jwk.Kid, alg: jwk.Alg}
+	switch jwk.Kty {
+	case "RSA":
+		n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+		e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+		if errN != nil || errE != nil {
+			return key, errors.New("malformed RSA key")
+		}
+		key.key = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		if len(key.alg) == 0 {
+			key.alg = "RS256"
+		}
+	case "EC":
+		if jwk.Crv != "P-256" {
+			return key, fmt.Errorf("unsupported curve %q", jwk.Crv)
+		}
+		x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+		y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+		if errX != nil || errY != nil {
+			return key, errors.New("malformed EC key")
+		}
+		key.key = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if len(key.alg) == 0 {
+			key.alg = "ES256"
+		}
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(jwk.K)
+		if err != nil || len(secret) == 0 {
+			return key, errors.New("malformed symmetric key")
+		}
+		key.key = secret
+		if len(key.alg) == 0 {
+			key.alg = "HS256"
+		}
+	default:
+		return key, fmt.Errorf("unsupported key type %q", jwk.Kty)
+	}
+	if !slices.Contains([]string{"RS256", "ES256", "HS256"}, key.alg) {
+		return key, fmt.Errorf("unsupported algorithm %q", key.alg)
+	}
+	return key, nil
+}
+
+// verifyJwtSignature verifies the signature of a token, the key type must match the algorithm
+func verifyJwtSignature(alg string, key any, signed []byte, signature []byte) bool {
+	hash := sha256.Sum256(signed)
+	switch alg {
+	case "RS256":
+		publicKey, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(publicKey, crypto.SHA256, hash[:], signature) == nil
+	case "ES256":
+		publicKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(publicKey, hash[:], r, s)
+	case "HS256":
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return hmac.Equal(mac.Sum(nil), signature)
+	}
+	return false
+}
+
+// decodeJwtSegment decodes a base64url encoded JSON segment of a token
+func decodeJwtSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// looksLikeJwt checks if the given bearer token has the structure of a JWT
+func looksLikeJwt(token string) bool {
+	return strings.Count(token, ".") == 2 && strings.HasPrefix(token, "eyJ")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testJwtSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestJwtValidator creates a validator using a JWKS file with the symmetric test key
+func newTestJwtValidator(t *testing.T, groupScopes map[string][]string, defaultScopes []string) *JwtValidator {
+	t.Helper()
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "oct", "kid": "test", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(testJwtSecret)},
+	}}
+	data, _ := json.Marshal(jwks)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	validator, err := NewJwtValidator(path, "https://idp.example.com", "ollama", time.Minute, groupScopes, nil, defaultScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return validator
+}
+
+// signTestJwt creates a HS256 token with the given claims signed by the test key,
+// the standard claims get valid defaults unless they are given.
+func signTestJwt(t *testing.T, claims map[string]any) string {
+	t.Helper()
+	defaults := map[string]any{
+		"iss": "https://idp.example.com",
+		"aud": "ollama",
+		"sub": "user-1",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for name, value := range defaults {
+		if _, found := claims[name]; !found {
+			claims[name] = value
+		}
+	}
+	header, _ := json.Marshal(map[string]string{"alg": "HS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, testJwtSecret)
+	mac.Write([]byte(signed))
+	return signed + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestJwtValidatorValidates(t *testing.T) {
+	validator := newTestJwtValidator(t, nil, nil)
+	tests := []struct {
+		name   string
+		claims map[string]any
+		valid  bool
+	}{
+		{"valid", map[string]any{}, true},
+		{"expired", map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{"within leeway", map[string]any{"exp": time.Now().Add(-30 * time.Second).Unix()}, true},
+		{"not yet valid", map[string]any{"nbf": time.Now().Add(time.Hour).Unix()}, false},
+		{"wrong issuer", map[string]any{"iss": "https://other.example.com"}, false},
+		{"wrong audience", map[string]any{"aud": []string{"other"}}, false},
+		{"audience list", map[string]any{"aud": []string{"other", "ollama"}}, true},
+		{"without subject", map[string]any{"sub": ""}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := validator.Validate(signTestJwt(t, tt.claims))
+			if (err == nil) != tt.valid {
+				t.Errorf("err = %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestJwtValidatorRejectsInvalidSignature(t *testing.T) {
+	validator := newTestJwtValidator(t, nil, nil)
+	token := signTestJwt(t, map[string]any{})
+
+	tampered := token[:len(token)-2] + "AA"
+	if _, err := validator.Validate(tampered); err == nil {
+		t.Error("token with invalid signature accepted")
+	}
+	if _, err := validator.Validate("not.a.token"); err == nil {
+		t.Error("malformed token accepted")
+	}
+}
+
+func TestJwtValidatorIdentityScopes(t *testing.T) {
+	groupScopes := map[string][]string{"ml-users": {ScopeChat, ScopeEmbed}}
+	validator := newTestJwtValidator(t, groupScopes, nil)
+	tests := []struct {
+		name   string
+		claims *JwtClaims
+		scopes []string
+	}{
+		{"scope claim", &JwtClaims{Subject: "user-1", Scope: stringList{"openid", "chat"}}, []string{ScopeChat}},
+		{"scp claim", &JwtClaims{Subject: "user-1", Scp: stringList{"models:read"}}, []string{ScopeModelsRead}},
+		{"group", &JwtClaims{Subject: "user-1", Groups: stringList{"ml-users"}}, []string{ScopeChat, ScopeEmbed}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			identity, err := validator.Identity(tt.claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(identity.Scopes, tt.scopes) {
+				t.Errorf("scopes = %v, want %v", identity.Scopes, tt.scopes)
+			}
+			if identity.HasScope(ScopeModelsWrite) {
+				t.Error("token got scope models:write")
+			}
+		})
+	}
+}
+
+func TestJwtValidatorIdentityWithoutKnownScope(t *testing.T) {
+	claims := &JwtClaims{Subject: "user-1", Scope: stringList{"openid", "profile"}, Groups: stringList{"unmapped"}}
+
+	if identity, err := newTestJwtValidator(t, nil, nil).Identity(claims); err == nil {
+		t.Errorf("identity = %+v, want token without known scope rejected", identity)
+	}
+
+	identity, err := newTestJwtValidator(t, nil, []string{ScopeChat}).Identity(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(identity.Scopes, []string{ScopeChat}) {
+		t.Errorf("scopes = %v, want default scopes", identity.Scopes)
+	}
+}
+
+func TestNewJwtValidatorRejectsUnknownDefaultScope(t *testing.T) {
+	if _, err := NewJwtValidator("/does/not/matter", "", "", 0, nil, nil, []string{"admin"}); err == nil {
+		t.Error("unknown default scope accepted")
+	}
+}
+
+func TestAuthenticateJwt(t *testing.T) {
+	s := newTestServerHandler(t)
+	s.SetJwtValidator(newTestJwtValidator(t, nil, nil))
+
+	r := httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestJwt(t, map[string]any{"scope": "chat", "name": "Jane"}))
+	identity, w := authenticate(s, r)
+	if identity == nil || identity.UserId != "user-1" || identity.UserName != "Jane" || identity.AuthMethod != AuthMethodJwt {
+		t.Errorf("identity = %+v, status %d, want identity of token", identity, w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/pull", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestJwt(t, map[string]any{"scope": "openid"}))
+	if identity, w := authenticate(s, r); identity != nil || w.Code != http.StatusForbidden {
+		t.Errorf("token without known scope: identity = %+v, status %d, want 403", identity, w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/api/chat", nil)
+	r.Header.Set("Authorization", "Bearer "+signTestJwt(t, map[string]any{"exp": time.Now().Add(-time.Hour).Unix()}))
+	if identity, w := authenticate(s, r); identity != nil || w.Code != http.StatusUnauthorized {
+		t.Errorf("expired token: identity = %+v, status %d, want 401", identity, w.Code)
+	}
+}
+
+func TestJwtValidatorLimitsForcedRefreshes(t *testing.T) {
+	var mutex sync.Mutex
+	requests := 0
+	jwks := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		failing := requests > 1
+		mutex.Unlock()
+		if failing {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{
+			{"kty": "oct", "kid": "test", "alg": "HS256", "k": base64.RawURLEncoding.EncodeToString(testJwtSecret)},
+		}})
+	}))
+	defer jwks.Close()
+	validator, err := NewJwtValidator(jwks.URL, "https://idp.example.com", "ollama", time.Minute, nil, nil, []string{ScopeChat})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validator.lastRefresh = time.Now().Add(-2 * jwksForcedRefreshInterval)
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","kid":"unknown"}`))
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := validator.Validate(header + ".e30.c2ln"); err == nil {
+				t.Error("token with unknown key id accepted")
+			}
+		}()
+	}
+	wg.Wait()
+
+	if requests != 2 {
+		t.Errorf("JWKS requests = %d, want initial load and a single forced refresh", requests)
+	}
+}
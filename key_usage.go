@@ -0,0 +1,71 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// KeyUsage is the usage of a key since start of the proxy
+type KeyUsage struct {
+	KeyName         string         `json:"key_name"`
+	Requests        int64          `json:"requests"`
+	PromptTokens    int64          `json:"prompt_tokens"`
+	EvalTokens      int64          `json:"eval_tokens"`
+	TotalDurationMs int64          `json:"total_duration_ms"`
+	Models          map[string]int `json:"models"`
+	LastModel       string         `json:"last_model"`
+	LastUsed        time.Time      `json:"last_used"`
+}
+
+// KeyUsageTracker aggregates the model usage metrics per key
+type KeyUsageTracker struct {
+	mutex sync.Mutex
+	usage map[string]*KeyUsage
+}
+
+// NewKeyUsageTracker will create a new key usage tracker
+func NewKeyUsageTracker() *KeyUsageTracker {
+	return &KeyUsageTracker{
+		usage: make(map[string]*KeyUsage),
+	}
+}
+
+// Record adds the given metrics to the usage of its key
+func (t *KeyUsageTracker) Record(metrics UserModelMetrics) {
+	keyName := metrics.KeyName
+	if len(keyName) == 0 {
+		keyName = "anonymous"
+	}
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	usage, found := t.usage[keyName]
+	if !found {
+		usage = &KeyUsage{KeyName: keyName, Models: make(map[string]int)}
+		t.usage[keyName] = usage
+	}
+	usage.Requests++
+	usage.PromptTokens += int64(metrics.PromptEvalCount)
+	usage.EvalTokens += int64(metrics.EvalCount)
+	usage.TotalDurationMs += metrics.TotalDuration.Milliseconds()
+	usage.Models[metrics.Model]++
+	usage.LastModel = metrics.Model
+	usage.LastUsed = time.Now()
+}
+
+// Usage returns the usage of all keys, most recently used first
+func (t *KeyUsageTracker) Usage() []KeyUsage {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	usage := make([]KeyUsage, 0, len(t.usage))
+	for _, u := range t.usage {
+		copied := *u
+		copied.Models = make(map[string]int, len(u.Models))
+		for model, count := range u.Models {
+			copied.Models[model] = count
+		}
+		usage = append(usage, copied)
+	}
+	sort.Slice(usage, func(i, j int) bool { return usage[i].LastUsed.After(usage[j].LastUsed) })
+	return usage
+}
@@ -0,0 +1,419 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ApiKey is an API key accepted by the proxy together with its per-key settings
+type ApiKey struct {
+	Name    string         `json:"name"`
+	Key     string         `json:"key"`
+	Rewrite *RewritePolicy `json:"rewrite,omitempty"`
+	NoCache bool           `json:"no_cache,omitempty"`
+	// PiiRedaction overrides the global setting of PII redaction for this key
+	PiiRedaction *bool `json:"pii_redaction,omitempty"`
+	// Scopes restrict the routes the key may use, see ScopeChat etc.
+	Scopes        []string `json:"scopes,omitempty"`
+	AllowedModels []string `json:"allowed_models,omitempty"`
+	// ClientCertSubjects are the common names or SANs of client certificates that authenticate as this key
+	ClientCertSubjects []string `json:"client_cert_subjects,omitempty"`
+	// RequireClientCertAndKey requires a matching client certificate and the API key
+	RequireClientCertAndKey bool `json:"require_client_cert_and_key,omitempty"`
+	// AllowedCidrs and DeniedCidrs restrict the client IPs that may use the key, in addition to the global rules
+	AllowedCidrs []string `json:"allowed_cidrs,omitempty"`
+	DeniedCidrs  []string `json:"denied_cidrs,omitempty"`
+	// OverrideGlobalCidrs replaces the global allowlist by the rules of the key, the global deny rules still apply
+	OverrideGlobalCidrs bool `json:"override_global_cidrs,omitempty"`
+	// ValidFrom and ExpiresAt limit the period the key can be used in
+	ValidFrom *time.Time `json:"valid_from,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Disabled rejects the key without removing it
+	Disabled bool `json:"disabled,omitempty"`
+
+	ipRules *IPRules
+	// fromFile is set for keys of the key store file, only those can be modified at runtime
+	fromFile bool
+}
+
+// keyStoreFile is the on-disk format of a key store file
+type keyStoreFile struct {
+	Keys []*ApiKey `json:"keys"`
+}
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+	ErrKeyExists   = errors.New("key already exists")
+	ErrKeyReadOnly = errors.New("key isn't part of the key store file")
+	ErrKeyNotSaved = errors.New("failed to save key store")
+	ErrKeyNoFile   = errors.New("key store has no file to save changes to")
+)
+
+// KeyStore holds all API keys accepted by the proxy
+type KeyStore struct {
+	mutex sync.RWMutex
+	keys  []*ApiKey
+	path  string
+
+	usageMutex sync.Mutex
+	lastUsed   map[string]time.Time
+}
+
+// NewKeyStore will create a new key store containing the given keys
+func NewKeyStore(keys []*ApiKey) *KeyStore {
+	return &KeyStore{
+		keys:     keys,
+		lastUsed: make(map[string]time.Time),
+	}
+}
+
+// LoadFile will add the keys of the given JSON key store file
+func (ks *KeyStore) LoadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read key store %s: %w", path, err)
+	}
+	var file keyStoreFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse key store %s: %w", path, err)
+	}
+
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	for i, key := range file.Keys {
+		key.Name = strings.TrimSpace(key.Name)
+		if len(key.Name) == 0 {
+			return fmt.Errorf("key store %s: key #%d has no name", path, i)
+		}
+		if err := key.validate(); err != nil {
+			return fmt.Errorf("key store %s: %w", path, err)
+		}
+		if ks.find(key.Name) >= 0 {
+			return fmt.Errorf("key store %s: key %s: %w", path, key.Name, ErrKeyExists)
+		}
+		key.fromFile = true
+		ks.keys = append(ks.keys, key)
+	}
+	ks.path = path
+	return nil
+}
+
+// validate checks the settings of the key and prepares it for usage
+func (k *ApiKey) validate() error {
+	var err error
+	k.Key = strings.TrimSpace(k.Key)
+	if len(k.Key) == 0 && len(k.ClientCertSubjects) == 0 {
+		return fmt.Errorf("key %s has no value", k.Name)
+	}
+	if k.RequireClientCertAndKey && (len(k.Key) == 0 || len(k.ClientCertSubjects) == 0) {
+		return fmt.Errorf("key %s requires value and client certificate subjects", k.Name)
+	}
+	if k.Rewrite != nil {
+		if err := k.Rewrite.Validate(); err != nil {
+			return fmt.Errorf("key %s: %w", k.Name, err)
+		}
+	}
+	if err := checkScopes(k.Scopes); err != nil {
+		return fmt.Errorf("key %s: %w", k.Name, err)
+	}
+	if k.ValidFrom != nil && k.ExpiresAt != nil && !k.ExpiresAt.After(*k.ValidFrom) {
+		return fmt.Errorf("key %s expires before it becomes valid", k.Name)
+	}
+	if k.ipRules, err = NewIPRules(k.AllowedCidrs, k.DeniedCidrs); err != nil {
+		return fmt.Errorf("key %s: %w", k.Name, err)
+	}
+	return nil
+}
+
+// Path returns the path of the key store file, empty when no file is used
+func (ks *KeyStore) Path() string {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.path
+}
+
+// Get returns the key with given name, or nil when the name is unknown
+func (ks *KeyStore) Get(name string) *ApiKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	if i := ks.find(name); i >= 0 {
+		return ks.keys[i]
+	}
+	return nil
+}
+
+// Add adds a new key and saves the key store file
+func (ks *KeyStore) Add(key *ApiKey) error {
+	key.Name = strings.TrimSpace(key.Name)
+	if len(key.Name) == 0 {
+		return errors.New("key has no name")
+	}
+	if err := key.validate(); err != nil {
+		return err
+	}
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	if len(ks.path) == 0 {
+		return fmt.Errorf("key %s: %w", key.Name, ErrKeyNoFile)
+	}
+	if ks.find(key.Name) >= 0 {
+		return fmt.Errorf("key %s: %w", key.Name, ErrKeyExists)
+	}
+	if len(key.Key) > 0 && ks.lookup(key.Key) != nil {
+		return fmt.Errorf("key %s: value is already used by another key", key.Name)
+	}
+	key.fromFile = true
+	ks.keys = append(ks.keys, key)
+	return ks.save()
+}
+
+// Update modifies a copy of the key with given name, replaces the key by the copy and saves the key store file.
+// Requests that are already authenticated keep using the unmodified key.
+func (ks *KeyStore) Update(name string, modify func(key *ApiKey) error) (*ApiKey, error) {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	i := ks.find(name)
+	if i < 0 {
+		return nil, fmt.Errorf("key %s: %w", name, ErrKeyNotFound)
+	}
+	if !ks.keys[i].fromFile {
+		return nil, fmt.Errorf("key %s: %w", name, ErrKeyReadOnly)
+	}
+	key := *ks.keys[i]
+	if err := modify(&key); err != nil {
+		return nil, err
+	}
+	key.Name = name
+	if err := key.validate(); err != nil {
+		return nil, err
+	}
+	if other := ks.lookup(key.Key); len(key.Key) > 0 && other != nil && other.Name != name {
+		return nil, fmt.Errorf("key %s: value is already used by another key", name)
+	}
+	ks.keys[i] = &key
+	return &key, ks.save()
+}
+
+// Delete removes the key with given name and saves the key store file
+func (ks *KeyStore) Delete(name string) error {
+	ks.mutex.Lock()
+	defer ks.mutex.Unlock()
+	i := ks.find(name)
+	if i < 0 {
+		return fmt.Errorf("key %s: %w", name, ErrKeyNotFound)
+	}
+	if !ks.keys[i].fromFile {
+		return fmt.Errorf("key %s: %w", name, ErrKeyReadOnly)
+	}
+	ks.keys = slices.Delete(ks.keys, i, i+1)
+	ks.usageMutex.Lock()
+	delete(ks.lastUsed, name)
+	ks.usageMutex.Unlock()
+	return ks.save()
+}
+
+// MarkUsed records the usage of the key with given name
+func (ks *KeyStore) MarkUsed(name string) {
+	ks.usageMutex.Lock()
+	defer ks.usageMutex.Unlock()
+	ks.lastUsed[name] = time.Now()
+}
+
+// LastUsed returns the time of the last usage of the key with given name, false when it wasn't used yet
+func (ks *KeyStore) LastUsed(name string) (time.Time, bool) {
+	ks.usageMutex.Lock()
+	defer ks.usageMutex.Unlock()
+	lastUsed, found := ks.lastUsed[name]
+	return lastUsed, found
+}
+
+// find returns the index of the key with given name or -1, the mutex must be held
+func (ks *KeyStore) find(name string) int {
+	return slices.IndexFunc(ks.keys, func(key *ApiKey) bool { return key.Name == name })
+}
+
+// save writes the keys of the key store file atomically, the mutex must be held
+func (ks *KeyStore) save() error {
+	if len(ks.path) == 0 {
+		return ErrKeyNoFile
+	}
+	file := keyStoreFile{Keys: make([]*ApiKey, 0)}
+	for _, key := range ks.keys {
+		if key.fromFile {
+			file.Keys = append(file.Keys, key)
+		}
+	}
+	data, err := json.MarshalIndent(file, "", "  ")
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrKeyNotSaved, ks.path, err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(ks.path), filepath.Base(ks.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("%w %s: %w", ErrKeyNotSaved, ks.path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return fmt.Errorf("%w %s: %w", ErrKeyNotSaved, ks.path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrKeyNotSaved, ks.path, err)
+	}
+	if err := os.Rename(tmp.Name(), ks.path); err != nil {
+		return fmt.Errorf("%w %s: %w", ErrKeyNotSaved, ks.path, err)
+	}
+	return nil
+}
+
+// Len returns the number of known keys
+func (ks *KeyStore) Len() int {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return len(ks.keys)
+}
+
+// Keys returns all known keys
+func (ks *KeyStore) Keys() []*ApiKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return slices.Clone(ks.keys)
+}
+
+// WatchExpiry warns about keys expiring within the given duration, checked hourly until the context is done
+func (ks *KeyStore) WatchExpiry(ctx context.Context, warnBefore time.Duration) {
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		for _, key := range ks.Keys() {
+			if key.ExpiresWithin(now, warnBefore) {
+				expiresIn, _ := key.ExpiresIn(now)
+				slog.Warn(fmt.Sprintf("API key %s expires in %s", key.Name, expiresIn.Round(time.Minute)), "key", key.Name, "expiresAt", key.ExpiresAt)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Lookup returns the key matching the given value, or nil when the value is unknown
+func (ks *KeyStore) Lookup(value string) *ApiKey {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return ks.lookup(value)
+}
+
+// lookup returns the key matching the given value, the mutex must be held
+func (ks *KeyStore) lookup(value string) *ApiKey {
+	value = strings.TrimSpace(value)
+	if len(value) == 0 {
+		return nil
+	}
+	for _, key := range ks.keys {
+		if subtle.ConstantTimeCompare([]byte(key.Key), []byte(value)) == 1 {
+			return key
+		}
+	}
+	return nil
+}
+
+// LookupClientCert returns the key matching the verified client certificate of the connection,
+// or nil when there is no verified certificate or no key matches its subject.
+func (ks *KeyStore) LookupClientCert(state *tls.ConnectionState) *ApiKey {
+	names := clientCertNames(state)
+	if len(names) == 0 {
+		return nil
+	}
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	for _, key := range ks.keys {
+		if key.matchesClientCert(names) {
+			return key
+		}
+	}
+	return nil
+}
+
+// OverridesGlobalIPRules checks if any key replaces the global allowlist by its own rules
+func (ks *KeyStore) OverridesGlobalIPRules() bool {
+	ks.mutex.RLock()
+	defer ks.mutex.RUnlock()
+	return slices.ContainsFunc(ks.keys, func(key *ApiKey) bool { return key.OverrideGlobalCidrs })
+}
+
+// MatchesClientCert checks if the verified client certificate of the connection belongs to the key
+func (k *ApiKey) MatchesClientCert(state *tls.ConnectionState) bool {
+	return k.matchesClientCert(clientCertNames(state))
+}
+
+func (k *ApiKey) matchesClientCert(names []string) bool {
+	for _, subject := range k.ClientCertSubjects {
+		if len(subject) > 0 && slices.Contains(names, subject) {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckClientIP returns whether the key may be used from the given client IP and otherwise the reason
+func (k *ApiKey) CheckClientIP(ip netip.Addr) (bool, string) {
+	return k.ipRules.Check(ip)
+}
+
+// CheckValidity returns an error when the key can't be used at the given time
+func (k *ApiKey) CheckValidity(now time.Time) error {
+	if k.Disabled {
+		return errors.New("API key disabled")
+	}
+	if k.ValidFrom != nil && now.Before(*k.ValidFrom) {
+		return fmt.Errorf("API key not valid before %s", k.ValidFrom.Format(time.RFC3339))
+	}
+	if k.ExpiresAt != nil && !now.Before(*k.ExpiresAt) {
+		return fmt.Errorf("API key expired at %s", k.ExpiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ExpiresIn returns the remaining validity of the key, false when the key doesn't expire
+func (k *ApiKey) ExpiresIn(now time.Time) (time.Duration, bool) {
+	if k.ExpiresAt == nil {
+		return 0, false
+	}
+	return max(k.ExpiresAt.Sub(now), 0), true
+}
+
+// ExpiresWithin checks if the key is usable but expires within the given period
+func (k *ApiKey) ExpiresWithin(now time.Time, period time.Duration) bool {
+	expiresIn, expires := k.ExpiresIn(now)
+	return !k.Disabled && expires && expiresIn > 0 && expiresIn <= period
+}
+
+// clientCertNames returns common name and SANs of the verified client certificate of the connection
+func clientCertNames(state *tls.ConnectionState) []string {
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	leaf := state.VerifiedChains[0][0]
+	names := []string{leaf.Subject.CommonName}
+	names = append(names, leaf.DNSNames...)
+	names = append(names, leaf.EmailAddresses...)
+	for _, uri := range leaf.URIs {
+		names = append(names, uri.String())
+	}
+	return names
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestApiKeyCheckValidity(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	tests := []struct {
+		name  string
+		key   ApiKey
+		valid bool
+	}{
+		{"without period", ApiKey{}, true},
+		{"within period", ApiKey{ValidFrom: &past, ExpiresAt: &future}, true},
+		{"not yet valid", ApiKey{ValidFrom: &future}, false},
+		{"expired", ApiKey{ExpiresAt: &past}, false},
+		{"disabled", ApiKey{Disabled: true}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.key.CheckValidity(now); (err == nil) != tt.valid {
+			t.Errorf("%s: err = %v, want valid %t", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestApiKeyExpiresIn(t *testing.T) {
+	now := time.Now()
+	expiresAt := now.Add(time.Hour)
+
+	if expiresIn, expires := (&ApiKey{ExpiresAt: &expiresAt}).ExpiresIn(now); !expires || expiresIn != time.Hour {
+		t.Errorf("expires in %s (%t), want an hour", expiresIn, expires)
+	}
+	if expiresIn, _ := (&ApiKey{ExpiresAt: &expiresAt}).ExpiresIn(now.Add(2 * time.Hour)); expiresIn != 0 {
+		t.Errorf("expired key expires in %s, want 0", expiresIn)
+	}
+	if _, expires := (&ApiKey{}).ExpiresIn(now); expires {
+		t.Error("key without expiry expires")
+	}
+}
+
+func TestApiKeyValidateRejectsInvalidPeriod(t *testing.T) {
+	validFrom := time.Now()
+	expiresAt := validFrom.Add(-time.Minute)
+	key := &ApiKey{Name: "key-1", Key: "value", ValidFrom: &validFrom, ExpiresAt: &expiresAt}
+
+	if err := key.validate(); err == nil {
+		t.Error("key expiring before it becomes valid accepted")
+	}
+}
+
+func TestKeyStoreSavesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(`{"keys": [{"name": "key-1", "key": "value-1"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ks := NewKeyStore([]*ApiKey{{Name: "env-key", Key: "env-value"}})
+	if err := ks.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ks.Add(&ApiKey{Name: "key-2", Key: "value-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Delete("key-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Delete("env-key"); !errors.Is(err, ErrKeyReadOnly) {
+		t.Errorf("err = %v, want key provided via env-var read-only", err)
+	}
+
+	reloaded := NewKeyStore(nil)
+	if err := reloaded.LoadFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if keys := reloaded.Keys(); len(keys) != 1 || keys[0].Name != "key-2" {
+		t.Errorf("saved keys = %+v, want key-2 only", keys)
+	}
+}
+
+func TestKeyStoreWithoutFileRejectsChanges(t *testing.T) {
+	ks := NewKeyStore([]*ApiKey{{Name: "env-key", Key: "env-value"}})
+
+	if err := ks.Add(&ApiKey{Name: "key-1", Key: "value-1"}); !errors.Is(err, ErrKeyNoFile) {
+		t.Errorf("err = %v, want change without key store file rejected", err)
+	}
+	if ks.Get("key-1") != nil {
+		t.Error("rejected key kept in memory")
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ServeHttpMetrics will be called by the http server to provide metrics in Prometheus text format.
+// Metrics of single keys reveal the names of all keys and are only provided for the admin API key,
+// other callers get the aggregated metrics.
+func (s *ServerHandler) ServeHttpMetrics(w http.ResponseWriter, r *http.Request) {
+	credential := s.credentials.Take(r)
+	admin := s.isAdminCredential(credential)
+	if admin {
+		if !s.authAdminCredential(w, r, credential) {
+			return
+		}
+	} else if _, ok := s.authRequestHandle(w, r, credential); !ok {
+		return
+	}
+	now := time.Now()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	expiring := 0
+	for _, key := range s.keyStore.Keys() {
+		if key.ExpiresWithin(now, s.keyExpiryWarn) {
+			expiring++
+		}
+	}
+	fmt.Fprintln(w, "# HELP ollama_proxy_api_keys_expiring Number of API keys expiring within the warning period")
+	fmt.Fprintln(w, "# TYPE ollama_proxy_api_keys_expiring gauge")
+	fmt.Fprintf(w, "ollama_proxy_api_keys_expiring %d\n", expiring)
+	if !admin {
+		return
+	}
+
+	fmt.Fprintln(w, "# HELP ollama_proxy_api_key_expires_in_seconds Remaining validity of API keys with expiry")
+	fmt.Fprintln(w, "# TYPE ollama_proxy_api_key_expires_in_seconds gauge")
+	for _, key := range s.keyStore.Keys() {
+		if expiresIn, expires := key.ExpiresIn(now); expires {
+			fmt.Fprintf(w, "ollama_proxy_api_key_expires_in_seconds{key=%s} %d\n", strconv.Quote(key.Name), int64(expiresIn.Seconds()))
+		}
+	}
+	fmt.Fprintln(w, "# HELP ollama_proxy_api_key_expiring Whether an API key expires within the warning period")
+	fmt.Fprintln(w, "# TYPE ollama_proxy_api_key_expiring gauge")
+	for _, key := range s.keyStore.Keys() {
+		if _, expires := key.ExpiresIn(now); expires {
+			expiring := 0
+			if key.ExpiresWithin(now, s.keyExpiryWarn) {
+				expiring = 1
+			}
+			fmt.Fprintf(w, "ollama_proxy_api_key_expiring{key=%s} %d\n", strconv.Quote(key.Name), expiring)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetricsRevealKeysToAdminOnly(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	expiredAt := time.Now().Add(-time.Hour)
+	s := newTestServerHandler(t,
+		&ApiKey{Name: "user-key", Key: "user-key-value"},
+		&ApiKey{Name: "expiring-key", Key: "expiring-key-value", ExpiresAt: &expiresAt},
+		&ApiKey{Name: "expired-key", Key: "expired-key-value", ExpiresAt: &expiredAt},
+		&ApiKey{Name: "disabled-key", Key: "disabled-key-value", ExpiresAt: &expiresAt, Disabled: true},
+	)
+	s.SetAdminApiKey("admin-key-value")
+	s.SetKeyExpiryWarning(24 * time.Hour)
+
+	metrics := func(key string) (int, string) {
+		r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+		r.Header.Set("Authorization", "Bearer "+key)
+		w := httptest.NewRecorder()
+		s.ServeHttpMetrics(w, r)
+		return w.Code, w.Body.String()
+	}
+
+	status, body := metrics("user-key-value")
+	if status != http.StatusOK || !strings.Contains(body, "ollama_proxy_api_keys_expiring 1\n") {
+		t.Errorf("status %d, body %q, want aggregated metrics", status, body)
+	}
+	if strings.Contains(body, "expiring-key") {
+		t.Errorf("body %q reveals key names to a regular key", body)
+	}
+
+	status, body = metrics("admin-key-value")
+	if status != http.StatusOK || !strings.Contains(body, `ollama_proxy_api_key_expiring{key="expiring-key"} 1`) {
+		t.Errorf("status %d, body %q, want metrics of single keys", status, body)
+	}
+	for _, key := range []string{"expired-key", "disabled-key"} {
+		if !strings.Contains(body, `ollama_proxy_api_key_expiring{key="`+key+`"} 0`) {
+			t.Errorf("body %q, want %s not expiring", body, key)
+		}
+	}
+
+	if status, _ := metrics("unknown-key"); status != http.StatusUnauthorized {
+		t.Errorf("status of unknown key = %d, want 401", status)
+	}
+}
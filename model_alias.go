@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ModelAliases maps virtual model names to concrete ollama models
+type ModelAliases map[string]string
+
+// modelFields are the fields of request bodies that name a model
+var modelFields = []string{"model", "name", "source"}
+
+// Resolve returns the concrete model for the given model name
+func (a ModelAliases) Resolve(model string) (string, bool) {
+	target, found := a[model]
+	return target, found
+}
+
+// ResolveRequest replaces aliases in the given request body by their concrete model
+// and returns the alias and the concrete model it was replaced by.
+func (a ModelAliases) ResolveRequest(body map[string]any) (alias string, target string) {
+	for _, field := range modelFields {
+		model, _ := body[field].(string)
+		if resolved, found := a.Resolve(model); found {
+			body[field] = resolved
+			alias, target = model, resolved
+		}
+	}
+	return alias, target
+}
+
+// AddVirtualModels appends an entry for every alias to the given
+// "/api/tags" response body, based on the entry of its concrete model.
+func (a ModelAliases) AddVirtualModels(data []byte) ([]byte, error) {
+	var tags map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&tags); err != nil {
+		return nil, err
+	}
+	models, _ := tags["models"].([]any)
+	concreteModels := make(map[string]map[string]any)
+	for _, model := range models {
+		if m, ok := model.(map[string]any); ok {
+			if name, ok := m["name"].(string); ok {
+				concreteModels[name] = m
+			}
+		}
+	}
+	for _, alias := range slices.Sorted(maps.Keys(a)) {
+		concrete, found := concreteModels[withDefaultTag(a[alias])]
+		if !found {
+			continue
+		}
+		virtual := maps.Clone(concrete)
+		virtual["name"] = alias
+		virtual["model"] = alias
+		models = append(models, virtual)
+	}
+	tags["models"] = models
+	return json.Marshal(tags)
+}
+
+// withDefaultTag adds the "latest" tag to a model name without tag, as ollama lists such a model with that tag
+func withDefaultTag(model string) string {
+	if strings.Contains(model[strings.LastIndex(model, "/")+1:], ":") {
+		return model
+	}
+	return model + ":latest"
+}
+
+// newModelAliasReverser returns a function that replaces the concrete model
+// by its alias in a chunk of a response body
+func newModelAliasReverser(alias string, target string) func(chunk []byte) []byte {
+	encodedAlias, _ := json.Marshal(alias)
+	encodedTarget, _ := json.Marshal(target)
+	pattern := regexp.MustCompile(`("(?:model|name)"\s*:\s*)` + regexp.QuoteMeta(string(encodedTarget)))
+	replacement := append([]byte("${1}"), encodedAlias...)
+	return func(chunk []byte) []byte {
+		return pattern.ReplaceAll(chunk, replacement)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestModelAliasesResolveRequest(t *testing.T) {
+	aliases := ModelAliases{"default-chat": "llama3.1:8b"}
+
+	body := map[string]any{"model": "default-chat", "prompt": "hi"}
+	alias, target := aliases.ResolveRequest(body)
+	if alias != "default-chat" || target != "llama3.1:8b" || body["model"] != "llama3.1:8b" {
+		t.Errorf("resolved %q to %q, body model %v", alias, target, body["model"])
+	}
+
+	concrete := map[string]any{"model": "qwen3:8b"}
+	if alias, _ := aliases.ResolveRequest(concrete); len(alias) > 0 || concrete["model"] != "qwen3:8b" {
+		t.Errorf("concrete model got resolved as alias %q", alias)
+	}
+}
+
+func TestModelAliasesAddVirtualModels(t *testing.T) {
+	aliases := ModelAliases{"default-chat": "llama3.1:8b", "missing": "unknown:latest"}
+	data := []byte(`{"models":[{"name":"llama3.1:8b","model":"llama3.1:8b","size":4920753328}]}`)
+
+	result, err := aliases.AddVirtualModels(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags struct {
+		Models []struct {
+			Name string      `json:"name"`
+			Size json.Number `json:"size"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(result, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags.Models) != 2 {
+		t.Fatalf("models = %+v, want concrete and one virtual model", tags.Models)
+	}
+	if tags.Models[1].Name != "default-chat" || tags.Models[1].Size != "4920753328" {
+		t.Errorf("virtual model = %+v, want copy of concrete model", tags.Models[1])
+	}
+}
+
+func TestModelAliasesAddVirtualModelsOfTargetWithoutTag(t *testing.T) {
+	aliases := ModelAliases{"default-chat": "qwen3", "registry-chat": "registry:5000/team/llm"}
+	data := []byte(`{"models":[{"name":"qwen3:latest","model":"qwen3:latest"},{"name":"registry:5000/team/llm:latest"}]}`)
+
+	result, err := aliases.AddVirtualModels(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tags struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"`
+	}
+	if err := json.Unmarshal(result, &tags); err != nil {
+		t.Fatal(err)
+	}
+	if len(tags.Models) != 4 || tags.Models[2].Name != "default-chat" || tags.Models[3].Name != "registry-chat" {
+		t.Errorf("models = %+v, want aliases of targets with implicit latest tag", tags.Models)
+	}
+}
+
+func TestModelAliasReverser(t *testing.T) {
+	reverse := newModelAliasReverser("default-chat", "llama3.1:8b")
+
+	chunk := reverse([]byte(`{"model": "llama3.1:8b","response":"llama3.1:8b is me"}`))
+
+	if want := `{"model": "default-chat","response":"llama3.1:8b is me"}`; string(chunk) != want {
+		t.Errorf("chunk = %s, want %s", chunk, want)
+	}
+}
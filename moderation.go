@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// ModerationResult is the verdict of a moderator about a text
+type ModerationResult struct {
+	Flagged bool   `json:"flagged"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Moderator checks texts of prompts and outputs for violations
+type Moderator interface {
+	Moderate(ctx context.Context, text string) (ModerationResult, error)
+}
+
+// ModeratorChain flags a text as soon as one of its moderators flags it
+type ModeratorChain []Moderator
+
+func (c ModeratorChain) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	for _, moderator := range c {
+		result, err := moderator.Moderate(ctx, text)
+		if err != nil || result.Flagged {
+			return result, err
+		}
+	}
+	return ModerationResult{}, nil
+}
+
+// RuleModerator flags texts containing one of the keywords or matching one of the patterns
+type RuleModerator struct {
+	keywords []string
+	patterns []*regexp.Regexp
+}
+
+// NewRuleModerator will create a new local rule based moderator
+func NewRuleModerator(keywords []string, patterns []string) (*RuleModerator, error) {
+	m := &RuleModerator{}
+	for _, keyword := range keywords {
+		m.keywords = append(m.keywords, strings.ToLower(keyword))
+	}
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid moderation pattern %q: %w", pattern, err)
+		}
+		m.patterns = append(m.patterns, re)
+	}
+	return m, nil
+}
+
+func (m *RuleModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	lowerText := strings.ToLower(text)
+	for _, keyword := range m.keywords {
+		if strings.Contains(lowerText, keyword) {
+			return ModerationResult{Flagged: true, Reason: fmt.Sprintf("keyword %q", keyword)}, nil
+		}
+	}
+	for _, pattern := range m.patterns {
+		if pattern.MatchString(text) {
+			return ModerationResult{Flagged: true, Reason: fmt.Sprintf("pattern %q", pattern)}, nil
+		}
+	}
+	return ModerationResult{}, nil
+}
+
+// HttpModerator asks an external HTTP classifier about a text.
+// The classifier receives a JSON body {"input": "<text>"} and must reply
+// with a JSON body {"flagged": true|false, "reason": "..."}.
+type HttpModerator struct {
+	url    string
+	apiKey string
+	client *http.Client
+}
+
+// NewHttpModerator will create a new moderator calling the classifier at the given url
+func NewHttpModerator(url string, apiKey string, timeout time.Duration) *HttpModerator {
+	return &HttpModerator{
+		url:    url,
+		apiKey: apiKey,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (m *HttpModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	var result ModerationResult
+	buf, err := json.Marshal(map[string]string{"input": text})
+	if err != nil {
+		return result, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(buf))
+	if err != nil {
+		return result, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(m.apiKey) > 0 {
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", m.apiKey))
+	}
+	response, err := m.client.Do(req)
+	if err != nil {
+		return result, err
+	}
+	defer response.Body.Close()
+	if (response.StatusCode / 100) != 2 {
+		return result, fmt.Errorf("moderation classifier replied with status %d", response.StatusCode)
+	}
+	if err := json.NewDecoder(response.Body).Decode(&result); err != nil {
+		return result, fmt.Errorf("failed to decode moderation result: %w", err)
+	}
+	return result, nil
+}
+
+// ModerationAction is what happens to a request when its prompt gets flagged
+type ModerationAction string
+
+const (
+	ModerationBlock    ModerationAction = "block"
+	ModerationAnnotate ModerationAction = "annotate"
+)
+
+// Moderation bundles the moderator and the settings of the moderation stage
+type Moderation struct {
+	Moderator  Moderator
+	Action     ModerationAction
+	FailClosed bool
+	ScanOutput bool
+}
+
+// outputScanBatchSize is the amount of new output text that triggers moderation of the output
+const outputScanBatchSize = 256
+
+// outputScanOverlap is the amount of already moderated output text that is moderated again with the new text,
+// so that words and patterns split across batches are still found
+const outputScanOverlap = 64
+
+// outputScanner moderates the text of a streamed output in batches
+type outputScanner struct {
+	moderator Moderator
+	overlap   string
+	pending   strings.Builder
+}
+
+// Scan adds the text of an output chunk and moderates the new text, preceded by the overlap with the previous batch,
+// when enough new text arrived, the chunk has no text or the chunk is the final one, i.e. the output is complete.
+func (o *outputScanner) Scan(ctx context.Context, chunkText string, final bool) (ModerationResult, error) {
+	o.pending.WriteString(chunkText)
+	if o.pending.Len() == 0 || (o.pending.Len() < outputScanBatchSize && len(chunkText) > 0 && !final) {
+		return ModerationResult{}, nil
+	}
+	text := o.overlap + o.pending.String()
+	o.pending.Reset()
+	start := max(len(text)-outputScanOverlap, 0)
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	o.overlap = text[start:]
+	return o.moderator.Moderate(ctx, text)
+}
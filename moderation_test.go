@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// countingModerator records the texts it has been asked about
+type countingModerator struct {
+	texts []string
+	flag  string
+}
+
+func (m *countingModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	m.texts = append(m.texts, text)
+	if len(m.flag) > 0 && strings.Contains(text, m.flag) {
+		return ModerationResult{Flagged: true, Reason: m.flag}, nil
+	}
+	return ModerationResult{}, nil
+}
+
+func TestRuleModerator(t *testing.T) {
+	moderator, err := NewRuleModerator([]string{"Forbidden Topic"}, []string{`\bsecret-\d+\b`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		text    string
+		flagged bool
+	}{
+		{"tell me about the forbidden topic", true},
+		{"what is secret-42?", true},
+		{"hello there", false},
+	}
+	for _, tt := range tests {
+		result, err := moderator.Moderate(context.Background(), tt.text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Flagged != tt.flagged {
+			t.Errorf("%q flagged = %t, want %t", tt.text, result.Flagged, tt.flagged)
+		}
+	}
+	if _, err := NewRuleModerator(nil, []string{"("}); err == nil {
+		t.Error("invalid pattern accepted")
+	}
+}
+
+func TestHttpModerator(t *testing.T) {
+	classifier := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer classifier-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body struct {
+			Input string `json:"input"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		json.NewEncoder(w).Encode(ModerationResult{Flagged: body.Input == "bad", Reason: "classifier"})
+	}))
+	defer classifier.Close()
+
+	result, err := NewHttpModerator(classifier.URL, "classifier-key", 0).Moderate(context.Background(), "bad")
+	if err != nil || !result.Flagged || result.Reason != "classifier" {
+		t.Errorf("result = %+v, err = %v, want flagged by classifier", result, err)
+	}
+	if _, err := NewHttpModerator(classifier.URL, "", 0).Moderate(context.Background(), "bad"); err == nil {
+		t.Error("failed classifier call not reported")
+	}
+}
+
+func TestOutputScannerBatches(t *testing.T) {
+	moderator := &countingModerator{}
+	scanner := &outputScanner{moderator: moderator}
+	ctx := context.Background()
+
+	scanner.Scan(ctx, "short", false)
+	if len(moderator.texts) != 0 {
+		t.Errorf("moderated %d times before a batch was complete", len(moderator.texts))
+	}
+	scanner.Scan(ctx, strings.Repeat("a", outputScanBatchSize), false)
+	if len(moderator.texts) != 1 {
+		t.Errorf("moderated %d times after a complete batch, want 1", len(moderator.texts))
+	}
+	scanner.Scan(ctx, "", false)
+	if len(moderator.texts) != 1 {
+		t.Errorf("moderated %d times without new text, want 1", len(moderator.texts))
+	}
+}
+
+func TestOutputScannerModeratesFinalChunk(t *testing.T) {
+	moderator := &countingModerator{flag: "bad"}
+	scanner := &outputScanner{moderator: moderator}
+
+	result, err := scanner.Scan(context.Background(), "a short bad reply", true)
+
+	if err != nil || !result.Flagged {
+		t.Errorf("result = %+v, err = %v, want short final output flagged", result, err)
+	}
+}
+
+func TestOutputScannerSendsNewTextWithOverlap(t *testing.T) {
+	moderator := &countingModerator{flag: "bad word"}
+	scanner := &outputScanner{moderator: moderator}
+	ctx := context.Background()
+
+	scanner.Scan(ctx, strings.Repeat("a", outputScanBatchSize-3)+"bad", false)
+	result, _ := scanner.Scan(ctx, " word"+strings.Repeat("b", outputScanBatchSize), false)
+
+	if !result.Flagged {
+		t.Error("text split across batches not flagged")
+	}
+	if len(moderator.texts) != 2 || len(moderator.texts[1]) != outputScanOverlap+outputScanBatchSize+5 {
+		t.Errorf("moderated %d texts, want second batch with new text and overlap only", len(moderator.texts))
+	}
+}
+
+func TestOutputModerationTerminatesResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data := `{"model":"llama3","response":"a short bad reply","done":true}`
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		io.WriteString(w, data)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	moderation := &Moderation{Moderator: &countingModerator{flag: "bad"}, Action: ModerationBlock, ScanOutput: true}
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler := NewProxyHandler(upstreamURL, nil, slog.Default())
+		handler.SetModeration(moderation, "")
+		handler.ProxyRequest(w, r)
+	}))
+	defer proxy.Close()
+
+	response, err := http.Post(proxy.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"llama3","stream":false}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("failed to read terminated response: %v", err)
+	}
+	if want := "{\"error\":\"Response terminated by content moderation\"}\n"; string(data) != want {
+		t.Errorf("body = %q, want %q", data, want)
+	}
+}
+
+// failingModerator fails to moderate any text
+type failingModerator struct{}
+
+func (failingModerator) Moderate(ctx context.Context, text string) (ModerationResult, error) {
+	return ModerationResult{}, errors.New("classifier unavailable")
+}
+
+func TestOutputModerationFailClosedTerminatesResponse(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"model":"llama3","response":"a reply","done":true}`)
+	}))
+	defer upstream.Close()
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	for _, failClosed := range []bool{false, true} {
+		moderation := &Moderation{Moderator: failingModerator{}, Action: ModerationBlock, FailClosed: failClosed, ScanOutput: true}
+		proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler := NewProxyHandler(upstreamURL, nil, slog.Default())
+			handler.SetModeration(moderation, "")
+			handler.ProxyRequest(w, r)
+		}))
+		response, err := http.Post(proxy.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"llama3"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(response.Body)
+		response.Body.Close()
+		proxy.Close()
+
+		if terminated := strings.Contains(string(data), "content moderation failed"); terminated != failClosed {
+			t.Errorf("fail closed %t: body = %q", failClosed, data)
+		}
+	}
+}
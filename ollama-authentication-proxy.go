@@ -7,6 +7,8 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"encoding/json"
 	"fmt"
 	"log"
 	"log/slog"
@@ -18,6 +20,7 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 // getLogLevel returns a log level
@@ -103,14 +106,16 @@ func getOllamaHostPort() string {
 	return host
 }
 
-// getApiKeys extracts API keys from environment variable(s)
-func getApiKeys() []string {
-	apiKeys := make([]string, 0)
+// getApiKeys extracts API keys from environment variable(s),
+// the name of the environment variable is used as name of the key.
+func getApiKeys() []*ApiKey {
+	apiKeys := make([]*ApiKey, 0)
 	for _, envVar := range os.Environ() {
 		if strings.HasPrefix(envVar, "AUTHORIZATION_APIKEY") {
-			apiKey := strings.TrimSpace(strings.SplitN(envVar, "=", 2)[1])
+			parts := strings.SplitN(envVar, "=", 2)
+			apiKey := strings.TrimSpace(parts[1])
 			if len(apiKey) > 0 {
-				apiKeys = append(apiKeys, apiKey)
+				apiKeys = append(apiKeys, &ApiKey{Name: parts[0], Key: apiKey})
 			}
 		}
 	}
@@ -118,6 +123,69 @@ func getApiKeys() []string {
 	return apiKeys
 }
 
+// getKeyStoreFile returns the path of a JSON file containing additional API keys
+func getKeyStoreFile() string {
+	var path = ""
+	if envPath, found := os.LookupEnv("AUTHORIZATION_KEYS_FILE"); found {
+		path = strings.TrimSpace(envPath)
+	}
+	return path
+}
+
+// getKeyExpiryWarning returns the duration before expiry of a key to start warning about it
+func getKeyExpiryWarning() time.Duration {
+	var warnBefore = 7 * 24 * time.Hour
+	if envWarn, found := os.LookupEnv("KEY_EXPIRY_WARNING"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envWarn)); err == nil && d >= 0 {
+			warnBefore = d
+		}
+	}
+	return warnBefore
+}
+
+// getRewritePolicy returns the global policy to rewrite ollama options of requests
+func getRewritePolicy() *RewritePolicy {
+	policy := &RewritePolicy{
+		MaxOptions:   make(map[string]float64),
+		ForceOptions: make(map[string]any),
+	}
+	for _, envVar := range os.Environ() {
+		parts := strings.SplitN(envVar, "=", 2)
+		name, value := parts[0], strings.TrimSpace(parts[1])
+		if len(value) == 0 {
+			continue
+		}
+		if option, found := strings.CutPrefix(name, "REWRITE_MAX_OPTION_"); found {
+			if limit, err := strconv.ParseFloat(value, 64); err == nil {
+				policy.MaxOptions[strings.ToLower(option)] = limit
+			} else {
+				slog.Error(fmt.Sprintf("Ignoring invalid %s", name), "error", err)
+			}
+		} else if option, found := strings.CutPrefix(name, "REWRITE_FORCE_OPTION_"); found {
+			var forced any
+			if err := json.Unmarshal([]byte(value), &forced); err != nil {
+				forced = value
+			}
+			policy.ForceOptions[strings.ToLower(option)] = forced
+		}
+	}
+	if envValue, found := os.LookupEnv("REWRITE_MAX_KEEP_ALIVE"); found {
+		policy.MaxKeepAlive = strings.TrimSpace(envValue)
+	}
+	if envValue, found := os.LookupEnv("REWRITE_KEEP_ALIVE"); found {
+		policy.KeepAlive = strings.TrimSpace(envValue)
+	}
+	if envValue, found := os.LookupEnv("REWRITE_THINK"); found {
+		if think, err := strconv.ParseBool(strings.TrimSpace(envValue)); err == nil {
+			policy.Think = &think
+		}
+	}
+	if envValue, found := os.LookupEnv("REWRITE_SYSTEM_PROMPT"); found {
+		policy.SystemPrompt = strings.TrimSpace(envValue)
+	}
+	return policy
+}
+
 // getPreloadModels extracts models names to be pre-loaded on startup from environment variable(s)
 func getPreloadModels() []string {
 	models := make([]string, 0)
@@ -133,6 +201,707 @@ func getPreloadModels() []string {
 	return models
 }
 
+// getPreloadFailurePolicy returns the policy deciding whether failed preloads make the proxy unready
+func getPreloadFailurePolicy() PreloadFailurePolicy {
+	if envPolicy, found := os.LookupEnv("PRELOAD_FAILURE_POLICY"); found {
+		if policy, ok := ParsePreloadFailurePolicy(envPolicy); ok {
+			return policy
+		}
+		slog.Warn(fmt.Sprintf("Ignoring unknown preload failure policy %s", envPolicy))
+	}
+	return PreloadFailureAny
+}
+
+// getPreloadWarmUp returns whether and how preloaded models get loaded into memory
+func getPreloadWarmUp() PreloadWarmUp {
+	warmUp := PreloadWarmUp{}
+	if envEnabled, found := os.LookupEnv("PRELOAD_WARMUP_ENABLED"); found {
+		warmUp.Enabled = strings.ToLower(envEnabled) == "true"
+	}
+	if envKeepAlive, found := os.LookupEnv("PRELOAD_KEEP_ALIVE"); found {
+		envKeepAlive = strings.TrimSpace(envKeepAlive)
+		// like ollama, a number is the keep-alive in seconds and a negative value keeps the model loaded forever
+		if seconds, err := strconv.Atoi(envKeepAlive); err == nil {
+			keepAlive := time.Duration(seconds) * time.Second
+			warmUp.KeepAlive = &keepAlive
+		} else if keepAlive, err := time.ParseDuration(envKeepAlive); err == nil {
+			warmUp.KeepAlive = &keepAlive
+		} else {
+			slog.Warn(fmt.Sprintf("Ignoring invalid preload keep-alive %s", envKeepAlive))
+		}
+	}
+	if envInterval, found := os.LookupEnv("PRELOAD_KEEPER_INTERVAL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envInterval)); err == nil && d > 0 {
+			warmUp.KeeperInterval = d
+		}
+	}
+	return warmUp
+}
+
+// getPreloadPulls returns how preloaded models get pulled
+func getPreloadPulls() PreloadPulls {
+	pulls := PreloadPulls{Concurrency: 1, Retries: 3, RetryBackoff: 5 * time.Second}
+	for envVar, n := range map[string]*int{
+		"PRELOAD_CONCURRENCY": &pulls.Concurrency,
+		"PRELOAD_RETRIES":     &pulls.Retries,
+	} {
+		if envNumber, found := os.LookupEnv(envVar); found {
+			if parsed, err := strconv.Atoi(strings.TrimSpace(envNumber)); err == nil && parsed >= 0 {
+				*n = parsed
+			}
+		}
+	}
+	if envBackoff, found := os.LookupEnv("PRELOAD_RETRY_BACKOFF"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envBackoff)); err == nil && d > 0 {
+			pulls.RetryBackoff = d
+		}
+	}
+	return pulls
+}
+
+// getPreloadRefresh returns whether and how preloaded models get refreshed
+func getPreloadRefresh() PreloadRefresh {
+	refresh := PreloadRefresh{}
+	if envInterval, found := os.LookupEnv("PRELOAD_REFRESH_INTERVAL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envInterval)); err == nil && d > 0 {
+			refresh.Interval = d
+		}
+	}
+	if envUnload, found := os.LookupEnv("PRELOAD_REFRESH_UNLOAD"); found {
+		refresh.Unload = strings.ToLower(envUnload) == "true"
+	}
+	return refresh
+}
+
+// getReadinessCriteria returns the criteria of the readiness endpoint
+func getReadinessCriteria() ReadinessCriteria {
+	criteria := ReadinessCriteria{RequirePreload: true, LoadedModels: make([]string, 0)}
+	if envRequire, found := os.LookupEnv("READINESS_REQUIRE_PRELOAD"); found {
+		criteria.RequirePreload = strings.ToLower(envRequire) == "true"
+	}
+	for _, envVar := range os.Environ() {
+		if strings.HasPrefix(envVar, "READINESS_LOADED_MODEL") {
+			model := strings.TrimSpace(strings.SplitN(envVar, "=", 2)[1])
+			if len(model) > 0 {
+				criteria.LoadedModels = append(criteria.LoadedModels, model)
+			}
+		}
+	}
+	return criteria
+}
+
+// getHealthCheckDurations returns the interval and the timeout of the background health checks of the upstream
+func getHealthCheckDurations() (interval time.Duration, timeout time.Duration) {
+	interval, timeout = 5*time.Second, 2*time.Second
+	for envVar, d := range map[string]*time.Duration{
+		"HEALTH_CHECK_INTERVAL": &interval,
+		"HEALTH_CHECK_TIMEOUT":  &timeout,
+	} {
+		if envDuration, found := os.LookupEnv(envVar); found {
+			if parsed, err := time.ParseDuration(strings.TrimSpace(envDuration)); err == nil && parsed > 0 {
+				*d = parsed
+			}
+		}
+	}
+	return interval, timeout
+}
+
+// getHealthCheckHistory returns the number of health check results to keep
+func getHealthCheckHistory() int {
+	var history = 20
+	if envHistory, found := os.LookupEnv("HEALTH_CHECK_HISTORY"); found {
+		if n, err := strconv.Atoi(strings.TrimSpace(envHistory)); err == nil && n > 0 {
+			history = n
+		}
+	}
+	return history
+}
+
+// getModelAliases extracts model aliases like "alias=model" from environment variable(s)
+func getModelAliases() ModelAliases {
+	aliases := make(ModelAliases)
+	for _, envVar := range os.Environ() {
+		if strings.HasPrefix(envVar, "MODEL_ALIAS") {
+			value := strings.TrimSpace(strings.SplitN(envVar, "=", 2)[1])
+			alias, model, found := strings.Cut(value, "=")
+			alias, model = strings.TrimSpace(alias), strings.TrimSpace(model)
+			if !found || len(alias) == 0 || len(model) == 0 {
+				slog.Error(fmt.Sprintf("Ignoring invalid model alias %q", value))
+				continue
+			}
+			aliases[alias] = model
+		}
+	}
+	return aliases
+}
+
+// getResponseCacheEnabled returns whether to cache responses of deterministic requests
+func getResponseCacheEnabled() bool {
+	if envBool, found := os.LookupEnv("RESPONSE_CACHE_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getResponseCacheTTL returns how long a cached response stays valid
+func getResponseCacheTTL() time.Duration {
+	var ttl = time.Hour
+	if envTTL, found := os.LookupEnv("RESPONSE_CACHE_TTL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envTTL)); err == nil && d > 0 {
+			ttl = d
+		}
+	}
+	return ttl
+}
+
+// getResponseCacheSize returns the max number of responses kept in memory
+func getResponseCacheSize() int {
+	var size = 1000
+	if envSize, found := os.LookupEnv("RESPONSE_CACHE_SIZE"); found {
+		if s, err := strconv.Atoi(envSize); err == nil && s > 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+// getResponseCacheMaxEntrySize returns the max size in bytes of a single cached response
+func getResponseCacheMaxEntrySize() int {
+	var size = 10 * 1024 * 1024
+	if envSize, found := os.LookupEnv("RESPONSE_CACHE_MAX_ENTRY_SIZE"); found {
+		if s, err := strconv.Atoi(envSize); err == nil && s > 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+// getResponseCacheDir returns the directory of the optional on-disk response cache
+func getResponseCacheDir() string {
+	var dir = ""
+	if envDir, found := os.LookupEnv("RESPONSE_CACHE_DIR"); found {
+		dir = strings.TrimSpace(envDir)
+	}
+	return dir
+}
+
+// getResponseCacheDirMaxSize returns the max size in bytes of the on-disk response cache
+func getResponseCacheDirMaxSize() int64 {
+	var size int64 = 1024 * 1024 * 1024
+	if envSize, found := os.LookupEnv("RESPONSE_CACHE_DIR_MAX_SIZE"); found {
+		if s, err := strconv.ParseInt(envSize, 10, 64); err == nil && s > 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+// getRequestCoalescingEnabled returns whether identical in-flight requests share one upstream call
+func getRequestCoalescingEnabled() bool {
+	if envBool, found := os.LookupEnv("REQUEST_COALESCING_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getAuditLogFile returns the path of the audit log file, audit logging is disabled when empty
+func getAuditLogFile() string {
+	var path = ""
+	if envPath, found := os.LookupEnv("AUDIT_LOG_FILE"); found {
+		path = strings.TrimSpace(envPath)
+	}
+	return path
+}
+
+// getAuditLogMaxSize returns the size in bytes when the audit log file gets rotated
+func getAuditLogMaxSize() int64 {
+	var size int64 = 100 * 1024 * 1024
+	if envSize, found := os.LookupEnv("AUDIT_LOG_MAX_SIZE"); found {
+		if s, err := strconv.ParseInt(envSize, 10, 64); err == nil && s > 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+// getAuditLogMaxFiles returns the number of rotated audit log files to keep
+func getAuditLogMaxFiles() int {
+	var files = 10
+	if envFiles, found := os.LookupEnv("AUDIT_LOG_MAX_FILES"); found {
+		if f, err := strconv.Atoi(envFiles); err == nil && f >= 0 {
+			files = f
+		}
+	}
+	return files
+}
+
+// getAuditLogMaxBodySize returns the max size in bytes of prompts/completions stored per audit record
+func getAuditLogMaxBodySize() int {
+	var size = 64 * 1024
+	if envSize, found := os.LookupEnv("AUDIT_LOG_MAX_BODY_SIZE"); found {
+		if s, err := strconv.Atoi(envSize); err == nil && s > 0 {
+			size = s
+		}
+	}
+	return size
+}
+
+// getAuditLogRedactPatterns extracts additional redaction regex patterns from environment variable(s)
+func getAuditLogRedactPatterns() []string {
+	patterns := make([]string, 0)
+	for _, envVar := range os.Environ() {
+		if strings.HasPrefix(envVar, "AUDIT_LOG_REDACT_PATTERN") {
+			pattern := strings.TrimSpace(strings.SplitN(envVar, "=", 2)[1])
+			if len(pattern) > 0 {
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// getModerationRules extracts keywords and regex patterns of the local moderation rule set from environment variable(s)
+func getModerationRules() (keywords []string, patterns []string) {
+	for _, envVar := range os.Environ() {
+		parts := strings.SplitN(envVar, "=", 2)
+		value := strings.TrimSpace(parts[1])
+		if len(value) == 0 {
+			continue
+		}
+		if strings.HasPrefix(parts[0], "MODERATION_KEYWORD") {
+			keywords = append(keywords, value)
+		} else if strings.HasPrefix(parts[0], "MODERATION_PATTERN") {
+			patterns = append(patterns, value)
+		}
+	}
+	return keywords, patterns
+}
+
+// getModerationUrl returns the URL of an external HTTP classifier used for moderation
+func getModerationUrl() string {
+	var url = ""
+	if envUrl, found := os.LookupEnv("MODERATION_URL"); found {
+		url = strings.TrimSpace(envUrl)
+	}
+	return url
+}
+
+// getModerationApiKey returns the API key used to authorize requests to the moderation classifier
+func getModerationApiKey() string {
+	var apiKey = ""
+	if envApiKey, found := os.LookupEnv("MODERATION_API_KEY"); found {
+		apiKey = strings.TrimSpace(envApiKey)
+	}
+	return apiKey
+}
+
+// getModerationTimeout returns the timeout of requests to the moderation classifier
+func getModerationTimeout() time.Duration {
+	var timeout = 5 * time.Second
+	if envTimeout, found := os.LookupEnv("MODERATION_TIMEOUT"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envTimeout)); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// getModerationAction returns what happens to flagged requests
+func getModerationAction() ModerationAction {
+	if envAction, found := os.LookupEnv("MODERATION_ACTION"); found {
+		if strings.ToLower(strings.TrimSpace(envAction)) == "annotate" {
+			return ModerationAnnotate
+		}
+	}
+	return ModerationBlock
+}
+
+// getModerationFailClosed returns whether to reject requests when moderation fails
+func getModerationFailClosed() bool {
+	if envBool, found := os.LookupEnv("MODERATION_FAIL_CLOSED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getModerationScanOutput returns whether to moderate streamed outputs too
+func getModerationScanOutput() bool {
+	if envBool, found := os.LookupEnv("MODERATION_SCAN_OUTPUT"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getPiiRedactionEnabled returns whether PII in prompts gets replaced by placeholders
+func getPiiRedactionEnabled() bool {
+	if envBool, found := os.LookupEnv("PII_REDACTION_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getPiiRestoreEnabled returns whether PII placeholders get restored in the response
+func getPiiRestoreEnabled() bool {
+	if envBool, found := os.LookupEnv("PII_RESTORE_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getTLSFiles returns the paths of the TLS certificate and key files, TLS is disabled when empty
+func getTLSFiles() (certFile string, keyFile string) {
+	if envPath, found := os.LookupEnv("TLS_CERT_FILE"); found {
+		certFile = strings.TrimSpace(envPath)
+	}
+	if envPath, found := os.LookupEnv("TLS_KEY_FILE"); found {
+		keyFile = strings.TrimSpace(envPath)
+	}
+	return certFile, keyFile
+}
+
+// getTLSClientCAFile returns the path of a CA bundle to verify client certificates, mTLS is disabled when empty
+func getTLSClientCAFile() string {
+	var path = ""
+	if envPath, found := os.LookupEnv("TLS_CLIENT_CA_FILE"); found {
+		path = strings.TrimSpace(envPath)
+	}
+	return path
+}
+
+// getTLSMinVersion returns the minimum TLS version accepted
+func getTLSMinVersion() uint16 {
+	var version uint16 = tls.VersionTLS12
+	if envVersion, found := os.LookupEnv("TLS_MIN_VERSION"); found {
+		if v, err := parseTLSVersion(strings.TrimSpace(envVersion)); err == nil {
+			version = v
+		} else {
+			slog.Error("Ignoring invalid TLS_MIN_VERSION", "error", err)
+		}
+	}
+	return version
+}
+
+// getTLSCipherSuites returns the TLS cipher suites accepted, nil means the default suites
+func getTLSCipherSuites() []uint16 {
+	if envSuites, found := os.LookupEnv("TLS_CIPHER_SUITES"); found {
+		if suites, err := parseCipherSuites(envSuites); err == nil && len(suites) > 0 {
+			return suites
+		} else if err != nil {
+			slog.Error("Ignoring invalid TLS_CIPHER_SUITES", "error", err)
+		}
+	}
+	return nil
+}
+
+// getTLSReloadInterval returns the interval to check certificate files for changes
+func getTLSReloadInterval() time.Duration {
+	var interval = 30 * time.Second
+	if envInterval, found := os.LookupEnv("TLS_RELOAD_INTERVAL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envInterval)); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// getHealthProbesAuthEnabled returns whether the readiness and startup probes need authorization
+func getHealthProbesAuthEnabled() bool {
+	if envBool, found := os.LookupEnv("HEALTH_PROBES_AUTH_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getTLSHealthEnabled returns whether the health listener uses TLS too
+func getTLSHealthEnabled() bool {
+	if envBool, found := os.LookupEnv("TLS_HEALTH_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getTLSRedirectPort returns the port of a HTTP listener redirecting to HTTPS, 0 disables the listener
+func getTLSRedirectPort() int {
+	var port = 0
+	if envPort, found := os.LookupEnv("TLS_REDIRECT_PORT"); found {
+		if p, err := strconv.Atoi(envPort); err == nil {
+			port = p
+		}
+	}
+	return port
+}
+
+// getCredentialSources returns the sources of an API key accepted besides the "Authorization: Bearer" header
+func getCredentialSources() CredentialSources {
+	var sources CredentialSources
+	if envBool, found := os.LookupEnv("AUTH_API_KEY_HEADER_ENABLED"); found {
+		sources.ApiKeyHeader = strings.ToLower(envBool) == "true"
+	}
+	if envBool, found := os.LookupEnv("AUTH_BASIC_ENABLED"); found {
+		sources.BasicAuth = strings.ToLower(envBool) == "true"
+	}
+	if envBool, found := os.LookupEnv("AUTH_QUERY_PARAM_ENABLED"); found {
+		sources.QueryParam = strings.ToLower(envBool) == "true"
+	}
+	return sources
+}
+
+// getCidrs extracts comma separated CIDRs from environment variable(s) with given prefix
+func getCidrs(prefix string) []string {
+	cidrs := make([]string, 0)
+	for _, envVar := range os.Environ() {
+		if strings.HasPrefix(envVar, prefix) {
+			for _, cidr := range strings.Split(strings.SplitN(envVar, "=", 2)[1], ",") {
+				if cidr = strings.TrimSpace(cidr); len(cidr) > 0 {
+					cidrs = append(cidrs, cidr)
+				}
+			}
+		}
+	}
+	return cidrs
+}
+
+// getBruteForceProtectionEnabled returns whether clients get blocked after failed authentication attempts
+func getBruteForceProtectionEnabled() bool {
+	if envBool, found := os.LookupEnv("BRUTE_FORCE_PROTECTION_ENABLED"); found {
+		if strings.ToLower(envBool) == "true" {
+			return true
+		}
+	}
+	return false
+}
+
+// getBruteForceMaxFailures returns the number of failed authentication attempts that ban a client
+func getBruteForceMaxFailures() int {
+	var maxFailures = 10
+	if envMax, found := os.LookupEnv("BRUTE_FORCE_MAX_FAILURES"); found {
+		if n, err := strconv.Atoi(strings.TrimSpace(envMax)); err == nil && n > 0 {
+			maxFailures = n
+		}
+	}
+	return maxFailures
+}
+
+// getBruteForceDurations returns the initial back-off, the ban duration and the window to remember failures
+func getBruteForceDurations() (backoff time.Duration, banDuration time.Duration, window time.Duration) {
+	backoff, banDuration, window = time.Second, 15*time.Minute, 15*time.Minute
+	for envVar, d := range map[string]*time.Duration{
+		"BRUTE_FORCE_BACKOFF":      &backoff,
+		"BRUTE_FORCE_BAN_DURATION": &banDuration,
+		"BRUTE_FORCE_WINDOW":       &window,
+	} {
+		if envDuration, found := os.LookupEnv(envVar); found {
+			if parsed, err := time.ParseDuration(strings.TrimSpace(envDuration)); err == nil && parsed > 0 {
+				*d = parsed
+			}
+		}
+	}
+	return backoff, banDuration, window
+}
+
+// getAdminApiKey returns the API key required to use the admin API
+func getAdminApiKey() string {
+	var apiKey = ""
+	if envApiKey, found := os.LookupEnv("ADMIN_APIKEY"); found {
+		apiKey = strings.TrimSpace(envApiKey)
+	}
+	return apiKey
+}
+
+// getAdminPort returns the port of a separate listener for the admin API, 0 serves the admin API at the proxy port
+func getAdminPort() int {
+	var port = 0
+	if envPort, found := os.LookupEnv("ADMIN_PORT"); found {
+		if p, err := strconv.Atoi(envPort); err == nil {
+			port = p
+		}
+	}
+	return port
+}
+
+// getAdminEventBodiesEnabled returns whether subscribers of the admin event stream may ask for request bodies
+func getAdminEventBodiesEnabled() bool {
+	var enabled = false
+	if envEnabled, found := os.LookupEnv("ADMIN_EVENTS_BODIES_ENABLED"); found {
+		enabled = strings.ToLower(envEnabled) == "true"
+	}
+	return enabled
+}
+
+// getJwtJwksSource returns the file path or URL of the JWKS used to validate JWT bearer tokens
+func getJwtJwksSource() string {
+	var source = ""
+	if envUrl, found := os.LookupEnv("JWT_JWKS_URL"); found {
+		source = strings.TrimSpace(envUrl)
+	}
+	if envFile, found := os.LookupEnv("JWT_JWKS_FILE"); found && len(source) == 0 {
+		source = strings.TrimSpace(envFile)
+	}
+	return source
+}
+
+// getJwtIssuer returns the required issuer of JWT bearer tokens
+func getJwtIssuer() string {
+	var issuer = ""
+	if envIssuer, found := os.LookupEnv("JWT_ISSUER"); found {
+		issuer = strings.TrimSpace(envIssuer)
+	}
+	return issuer
+}
+
+// getJwtAudience returns the required audience of JWT bearer tokens
+func getJwtAudience() string {
+	var audience = ""
+	if envAudience, found := os.LookupEnv("JWT_AUDIENCE"); found {
+		audience = strings.TrimSpace(envAudience)
+	}
+	return audience
+}
+
+// getJwtLeeway returns the allowed clock skew when checking the validity period of JWT bearer tokens
+func getJwtLeeway() time.Duration {
+	var leeway = time.Minute
+	if envLeeway, found := os.LookupEnv("JWT_LEEWAY"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envLeeway)); err == nil && d >= 0 {
+			leeway = d
+		}
+	}
+	return leeway
+}
+
+// getJwtJwksRefreshInterval returns the interval to reload the JWKS
+func getJwtJwksRefreshInterval() time.Duration {
+	var interval = 10 * time.Minute
+	if envInterval, found := os.LookupEnv("JWT_JWKS_REFRESH_INTERVAL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envInterval)); err == nil && d > 0 {
+			interval = d
+		}
+	}
+	return interval
+}
+
+// getJwtGroupMappings extracts mappings like "group=value1,value2" from environment variable(s) with given prefix
+func getJwtGroupMappings(prefix string) map[string][]string {
+	mappings := make(map[string][]string)
+	for _, envVar := range os.Environ() {
+		if strings.HasPrefix(envVar, prefix) {
+			value := strings.TrimSpace(strings.SplitN(envVar, "=", 2)[1])
+			group, values, found := strings.Cut(value, "=")
+			group = strings.TrimSpace(group)
+			if !found || len(group) == 0 {
+				slog.Error(fmt.Sprintf("Ignoring invalid JWT group mapping %q", value))
+				continue
+			}
+			for _, v := range strings.Split(values, ",") {
+				if v = strings.TrimSpace(v); len(v) > 0 {
+					mappings[group] = append(mappings[group], v)
+				}
+			}
+		}
+	}
+	return mappings
+}
+
+// getJwtDefaultScopes returns the scopes granted to JWT bearer tokens without any known scope
+func getJwtDefaultScopes() []string {
+	var scopes = make([]string, 0)
+	if envScopes, found := os.LookupEnv("JWT_DEFAULT_SCOPES"); found {
+		for _, scope := range strings.Split(envScopes, ",") {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
+// getIntrospectionUrl returns the URL of a RFC 7662 endpoint to introspect opaque bearer tokens
+func getIntrospectionUrl() string {
+	var url = ""
+	if envUrl, found := os.LookupEnv("INTROSPECTION_URL"); found {
+		url = strings.TrimSpace(envUrl)
+	}
+	return url
+}
+
+// getIntrospectionClientCredentials returns the client credentials used to call the introspection endpoint
+func getIntrospectionClientCredentials() (clientId string, clientSecret string) {
+	if envClientId, found := os.LookupEnv("INTROSPECTION_CLIENT_ID"); found {
+		clientId = strings.TrimSpace(envClientId)
+	}
+	if envClientSecret, found := os.LookupEnv("INTROSPECTION_CLIENT_SECRET"); found {
+		clientSecret = strings.TrimSpace(envClientSecret)
+	}
+	return clientId, clientSecret
+}
+
+// getIntrospectionTimeout returns the timeout of a call to the introspection endpoint
+func getIntrospectionTimeout() time.Duration {
+	var timeout = 5 * time.Second
+	if envTimeout, found := os.LookupEnv("INTROSPECTION_TIMEOUT"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envTimeout)); err == nil && d > 0 {
+			timeout = d
+		}
+	}
+	return timeout
+}
+
+// getIntrospectionCacheTTLs returns how long active and inactive introspection results are cached
+func getIntrospectionCacheTTLs() (positiveTTL time.Duration, negativeTTL time.Duration) {
+	positiveTTL, negativeTTL = 5*time.Minute, 30*time.Second
+	if envTTL, found := os.LookupEnv("INTROSPECTION_CACHE_TTL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envTTL)); err == nil && d >= 0 {
+			positiveTTL = d
+		}
+	}
+	if envTTL, found := os.LookupEnv("INTROSPECTION_NEGATIVE_CACHE_TTL"); found {
+		if d, err := time.ParseDuration(strings.TrimSpace(envTTL)); err == nil && d >= 0 {
+			negativeTTL = d
+		}
+	}
+	return positiveTTL, negativeTTL
+}
+
+// getIntrospectionRateLimit returns the max number of calls to the introspection endpoint per second
+func getIntrospectionRateLimit() float64 {
+	var rateLimit = 10.0
+	if envRate, found := os.LookupEnv("INTROSPECTION_RATE_LIMIT"); found {
+		if r, err := strconv.ParseFloat(strings.TrimSpace(envRate), 64); err == nil && r > 0 {
+			rateLimit = r
+		}
+	}
+	return rateLimit
+}
+
+// getIntrospectionDefaultScopes returns the scopes granted to introspected tokens without any known scope
+func getIntrospectionDefaultScopes() []string {
+	var scopes = make([]string, 0)
+	if envScopes, found := os.LookupEnv("INTROSPECTION_DEFAULT_SCOPES"); found {
+		for _, scope := range strings.Split(envScopes, ",") {
+			if scope = strings.TrimSpace(scope); len(scope) > 0 {
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+	return scopes
+}
+
 // getUserModelMetricsWebhookUrl returns the URL of a webhook that will receive user model metrics
 func getUserModelMetricsWebhookUrl() string {
 	var url = ""
@@ -186,6 +955,10 @@ func main() {
 	var port = getPort()
 	var portHealth = getPortHealth()
 	var apiKeys = getApiKeys()
+	var keyStoreFile = getKeyStoreFile()
+	var keyExpiryWarning = getKeyExpiryWarning()
+	var rewritePolicy = getRewritePolicy()
+	var modelAliases = getModelAliases()
 	var preloadModels = getPreloadModels()
 	var userModelMetricsWebhookUrl = getUserModelMetricsWebhookUrl()
 	var userModelMetricsWebhookApiKey = getUserModelMetricsWebhookApiKey()
@@ -197,31 +970,238 @@ func main() {
 		log.Fatal(err)
 	}
 
-	serverHandler := NewServerHandler(apiKeys, preloadModels)
+	if err := rewritePolicy.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	keyStore := NewKeyStore(apiKeys)
+	if len(keyStoreFile) > 0 {
+		if err := keyStore.LoadFile(keyStoreFile); err != nil {
+			log.Fatal(err)
+		}
+		slog.Info(fmt.Sprintf("Using %d API keys in total", keyStore.Len()))
+	}
+
+	serverHandler := NewServerHandler(keyStore, preloadModels)
+	serverHandler.SetContext(ctx)
+	serverHandler.SetKeyExpiryWarning(keyExpiryWarning)
+	go keyStore.WatchExpiry(ctx, keyExpiryWarning)
 	serverHandler.SetUpstreamURL(backendURL)
+	healthCheckInterval, healthCheckTimeout := getHealthCheckDurations()
+	healthChecker := NewHealthChecker(backendURL, healthCheckInterval, healthCheckTimeout, getHealthCheckHistory())
+	readinessCriteria := getReadinessCriteria()
+	if len(readinessCriteria.LoadedModels) > 0 {
+		healthChecker.ListLoadedModels()
+	}
+	go healthChecker.Run(ctx)
+	serverHandler.SetHealthChecker(healthChecker)
+	serverHandler.SetReadinessCriteria(readinessCriteria)
+	serverHandler.SetHealthProbesAuth(getHealthProbesAuthEnabled())
+	serverHandler.SetPreloadFailurePolicy(getPreloadFailurePolicy())
+	serverHandler.SetPreloadWarmUp(getPreloadWarmUp())
+	serverHandler.SetPreloadPulls(getPreloadPulls())
+	serverHandler.SetPreloadRefresh(getPreloadRefresh())
+	serverHandler.SetRewritePolicy(rewritePolicy)
+	serverHandler.SetCredentialSources(getCredentialSources())
+
+	ipRules, err := NewIPRules(getCidrs("ACCESS_ALLOW_CIDR"), getCidrs("ACCESS_DENY_CIDR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	ipResolver, err := NewClientIPResolver(getCidrs("TRUSTED_PROXY_CIDR"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	serverHandler.SetIPRules(ipRules, ipResolver)
+
+	if getBruteForceProtectionEnabled() {
+		if len(getCidrs("TRUSTED_PROXY_CIDR")) == 0 {
+			slog.Warn("Brute-force protection without trusted proxies, clients behind a shared proxy block each other")
+		}
+		backoff, banDuration, window := getBruteForceDurations()
+		bruteForceGuard := NewBruteForceGuard(getBruteForceMaxFailures(), backoff, banDuration, window)
+		go bruteForceGuard.Run(ctx, time.Minute)
+		serverHandler.SetBruteForceGuard(bruteForceGuard)
+	}
+	adminApiKey := getAdminApiKey()
+	if len(adminApiKey) > 0 && len(keyStoreFile) == 0 {
+		slog.Warn("Admin API can't change keys without key store file ( AUTHORIZATION_KEYS_FILE )")
+	}
+	serverHandler.SetAdminApiKey(adminApiKey)
+	serverHandler.SetEventBodies(getAdminEventBodiesEnabled())
+
+	if jwksSource := getJwtJwksSource(); len(jwksSource) > 0 {
+		jwtValidator, err := NewJwtValidator(jwksSource, getJwtIssuer(), getJwtAudience(), getJwtLeeway(),
+			getJwtGroupMappings("JWT_GROUP_SCOPES"), getJwtGroupMappings("JWT_GROUP_MODELS"), getJwtDefaultScopes())
+		if err != nil {
+			log.Fatal(err)
+		}
+		go jwtValidator.Run(ctx, getJwtJwksRefreshInterval())
+		serverHandler.SetJwtValidator(jwtValidator)
+	}
+
+	if introspectionUrl := getIntrospectionUrl(); len(introspectionUrl) > 0 {
+		clientId, clientSecret := getIntrospectionClientCredentials()
+		positiveTTL, negativeTTL := getIntrospectionCacheTTLs()
+		introspector, err := NewTokenIntrospector(introspectionUrl, clientId, clientSecret, getIntrospectionTimeout(), positiveTTL, negativeTTL, getIntrospectionDefaultScopes())
+		if err != nil {
+			log.Fatal(err)
+		}
+		introspector.SetRateLimit(getIntrospectionRateLimit())
+		serverHandler.SetTokenIntrospector(introspector)
+	}
+	serverHandler.SetModelAliases(modelAliases)
+
+	if getResponseCacheEnabled() {
+		cacheBackends := []CacheBackend{NewMemoryCache(getResponseCacheSize())}
+		if cacheDir := getResponseCacheDir(); len(cacheDir) > 0 {
+			diskCache, err := NewDiskCache(cacheDir, getResponseCacheDirMaxSize())
+			if err != nil {
+				log.Fatal(err)
+			}
+			go diskCache.Run(ctx, 5*time.Minute)
+			cacheBackends = append(cacheBackends, diskCache)
+			slog.Info(fmt.Sprintf("Using response cache directory %s", cacheDir))
+		}
+		responseCache := NewResponseCache(getResponseCacheTTL(), getResponseCacheMaxEntrySize(), cacheBackends...)
+		serverHandler.SetResponseCache(responseCache)
+		slog.Info("Using response cache")
+	}
+
+	if getRequestCoalescingEnabled() {
+		serverHandler.SetRequestCoalescer(NewRequestCoalescer())
+		slog.Info("Using request coalescing")
+	}
+
+	serverHandler.SetPiiRedaction(getPiiRedactionEnabled(), getPiiRestoreEnabled())
+
+	moderators := make(ModeratorChain, 0)
+	if keywords, patterns := getModerationRules(); len(keywords) > 0 || len(patterns) > 0 {
+		ruleModerator, err := NewRuleModerator(keywords, patterns)
+		if err != nil {
+			log.Fatal(err)
+		}
+		moderators = append(moderators, ruleModerator)
+		slog.Info(fmt.Sprintf("Using %d moderation keywords and %d patterns", len(keywords), len(patterns)))
+	}
+	if moderationUrl := getModerationUrl(); len(moderationUrl) > 0 {
+		moderators = append(moderators, NewHttpModerator(moderationUrl, getModerationApiKey(), getModerationTimeout()))
+		slog.Info(fmt.Sprintf("Using moderation classifier at %s", moderationUrl))
+	}
+	if len(moderators) > 0 {
+		serverHandler.SetModeration(&Moderation{
+			Moderator:  moderators,
+			Action:     getModerationAction(),
+			FailClosed: getModerationFailClosed(),
+			ScanOutput: getModerationScanOutput(),
+		})
+	}
+
+	var auditLog *AuditLog = nil
+	if auditLogFile := getAuditLogFile(); len(auditLogFile) > 0 {
+		auditLog, err = NewAuditLog(auditLogFile, getAuditLogMaxSize(), getAuditLogMaxFiles(), getAuditLogMaxBodySize(), getAuditLogRedactPatterns())
+		if err != nil {
+			log.Fatal(err)
+		}
+		serverHandler.SetAuditLog(auditLog)
+		slog.Info(fmt.Sprintf("Using audit log %s", auditLogFile))
+	}
 	serverHandler.SetUserModelMetricsWebhook(userModelMetricsWebhookUrl, userModelMetricsWebhookApiKey)
 
 	serverHandlerFuncs := make(map[string]func(http.ResponseWriter, *http.Request))
 	serverHandlerFuncs["/"] = serverHandler.ServeHttpProxy
 
+	adminFuncs := make(map[string]func(http.ResponseWriter, *http.Request))
+	adminFuncs["GET /admin/bans"] = serverHandler.ServeHttpAdminBans
+	adminFuncs["DELETE /admin/bans/{ip}"] = serverHandler.ServeHttpAdminUnban
+	adminFuncs["GET /admin/keys"] = serverHandler.ServeHttpAdminKeys
+	adminFuncs["POST /admin/keys"] = serverHandler.ServeHttpAdminCreateKey
+	adminFuncs["GET /admin/keys/{name}"] = serverHandler.ServeHttpAdminKey
+	adminFuncs["DELETE /admin/keys/{name}"] = serverHandler.ServeHttpAdminDeleteKey
+	adminFuncs["POST /admin/keys/{name}/disable"] = serverHandler.ServeHttpAdminDisableKey
+	adminFuncs["POST /admin/keys/{name}/enable"] = serverHandler.ServeHttpAdminEnableKey
+	adminFuncs["POST /admin/keys/{name}/rotate"] = serverHandler.ServeHttpAdminRotateKey
+	adminFuncs["GET /admin/status"] = serverHandler.ServeHttpAdminStatus
+	adminFuncs["GET /admin/ps"] = serverHandler.ServeHttpAdminPs
+	adminFuncs["GET /admin/requests"] = serverHandler.ServeHttpAdminRequests
+	adminFuncs["GET /admin/usage"] = serverHandler.ServeHttpAdminUsage
+	adminFuncs["POST /admin/models/pull"] = serverHandler.ServeHttpAdminPullModel
+	adminFuncs["POST /admin/models/unload"] = serverHandler.ServeHttpAdminUnloadModel
+	adminFuncs["GET /admin/events"] = serverHandler.ServeHttpAdminEvents
+	adminFuncs["GET /admin/{$}"] = serverHandler.ServeHttpDashboard
+
+	var tlsConfig *tls.Config = nil
+	if certFile, keyFile := getTLSFiles(); len(certFile) > 0 || len(keyFile) > 0 {
+		certReloader, err := NewCertificateReloader(certFile, keyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		go certReloader.Watch(ctx, getTLSReloadInterval())
+		tlsConfig = NewTLSConfig(certReloader, getTLSMinVersion(), getTLSCipherSuites())
+		slog.Info(fmt.Sprintf("Using TLS certificate %s", certFile))
+		if clientCAFile := getTLSClientCAFile(); len(clientCAFile) > 0 {
+			clientCAs, err := loadCertPool(clientCAFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			tlsConfig.ClientCAs = clientCAs
+			tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+			slog.Info(fmt.Sprintf("Verifying client certificates with CA bundle %s", clientCAFile))
+		}
+	}
+
 	var serverPing *Server = nil
 	if port != portHealth {
 		pingFuncs := make(map[string]func(http.ResponseWriter, *http.Request))
 		pingFuncs["GET /ping"] = serverHandler.ServeHttpPing
 		pingFuncs["GET /ping/"] = serverHandler.ServeHttpPing
+		pingFuncs["GET /metrics"] = serverHandler.ServeHttpMetrics
+		pingFuncs["GET /livez"] = serverHandler.ServeHttpLivez
+		pingFuncs["GET /readyz"] = serverHandler.ServeHttpReadyz
+		pingFuncs["GET /startupz"] = serverHandler.ServeHttpStartupz
+		pingFuncs["GET /health/details"] = serverHandler.ServeHttpHealthDetails
 		serverPing = NewServer(ctx, host, portHealth, pingFuncs)
+		if tlsConfig != nil && getTLSHealthEnabled() {
+			serverPing.SetTLSConfig(tlsConfig)
+		}
 		go serverPing.Run()
-		pingUrl := fmt.Sprintf("http://%s", serverPing.Addr)
-		slog.Info(fmt.Sprintf("Ping listening at %s", pingUrl))
+		slog.Info(fmt.Sprintf("Ping listening at %s", serverPing.URL()))
 	} else {
 		serverHandlerFuncs["GET /ping"] = serverHandler.ServeHttpPing
 		serverHandlerFuncs["GET /ping/"] = serverHandler.ServeHttpPing
+		serverHandlerFuncs["GET /metrics"] = serverHandler.ServeHttpMetrics
+		serverHandlerFuncs["GET /livez"] = serverHandler.ServeHttpLivez
+		serverHandlerFuncs["GET /readyz"] = serverHandler.ServeHttpReadyz
+		serverHandlerFuncs["GET /startupz"] = serverHandler.ServeHttpStartupz
+		serverHandlerFuncs["GET /health/details"] = serverHandler.ServeHttpHealthDetails
+	}
+
+	var serverAdmin *Server = nil
+	if adminPort := getAdminPort(); adminPort > 0 && adminPort != port {
+		serverAdmin = NewServer(ctx, host, adminPort, adminFuncs)
+		if tlsConfig != nil {
+			serverAdmin.SetTLSConfig(tlsConfig)
+		}
+		go serverAdmin.Run()
+		slog.Info(fmt.Sprintf("Admin API listening at %s", serverAdmin.URL()))
+	} else {
+		for pattern, handler := range adminFuncs {
+			serverHandlerFuncs[pattern] = handler
+		}
 	}
 
 	server := NewServer(ctx, host, port, serverHandlerFuncs)
+	var serverRedirect *Server = nil
+	if tlsConfig != nil {
+		server.SetTLSConfig(tlsConfig)
+		if redirectPort := getTLSRedirectPort(); redirectPort > 0 {
+			serverRedirect = NewRedirectServer(ctx, host, redirectPort, port)
+			go serverRedirect.Run()
+			slog.Info(fmt.Sprintf("Redirecting to HTTPS from %s", serverRedirect.URL()))
+		}
+	}
 	go server.Run()
-	serverUrl := fmt.Sprintf("http://%s", server.Addr)
-	slog.Info(fmt.Sprintf("Authenticating proxy listening at %s", serverUrl))
+	slog.Info(fmt.Sprintf("Authenticating proxy listening at %s", server.URL()))
 
 	sigs := make(chan os.Signal, 1)
 	done := make(chan bool, 1)
@@ -245,10 +1225,29 @@ func main() {
 			return
 		}
 	}
+	if serverRedirect != nil {
+		slog.Info("Shutdown redirect server")
+		if shutdownErr := serverRedirect.Shutdown(context.Background()); shutdownErr != nil {
+			slog.Error("Failed to shutdown redirect server", "error", shutdownErr)
+			return
+		}
+	}
+	if serverAdmin != nil {
+		slog.Info("Shutdown admin server")
+		if shutdownErr := serverAdmin.Shutdown(context.Background()); shutdownErr != nil {
+			slog.Error("Failed to shutdown admin server", "error", shutdownErr)
+			return
+		}
+	}
 	slog.Info("Shutdown server")
 	if shutdownErr := server.Shutdown(context.Background()); shutdownErr != nil {
 		slog.Error("Failed to shutdown server", "error", shutdownErr)
 		return
 	}
+	if auditLog != nil {
+		if closeErr := auditLog.Close(); closeErr != nil {
+			slog.Error("Failed to close audit log", "error", closeErr)
+		}
+	}
 	slog.Info("Done.")
 }
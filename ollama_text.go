@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+)
+
+// responseChunk contains the fields of a streamed ollama or OpenAI compatible response chunk that hold text
+type responseChunk struct {
+	Response string `json:"response"`
+	Message  struct {
+		Content string `json:"content"`
+	} `json:"message"`
+	Choices []struct {
+		Text  string `json:"text"`
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	} `json:"choices"`
+}
+
+// extractResponseText returns the generated text of a response chunk
+func extractResponseText(chunk []byte) string {
+	chunk = bytes.TrimSpace(chunk)
+	chunk = bytes.TrimPrefix(chunk, []byte("data:"))
+	chunk = bytes.TrimSpace(chunk)
+	if len(chunk) == 0 || chunk[0] != '{' {
+		return ""
+	}
+	var parsed responseChunk
+	if err := json.Unmarshal(chunk, &parsed); err != nil {
+		return ""
+	}
+	text := parsed.Response + parsed.Message.Content
+	for _, choice := range parsed.Choices {
+		text += choice.Text + choice.Delta.Content + choice.Message.Content
+	}
+	return text
+}
+
+// extractRequestText returns the text of prompts and messages of a chat/generate request body
+func extractRequestText(route string, body map[string]any) string {
+	texts := make([]string, 0)
+	switch route {
+	case "/api/generate", "/v1/completions":
+		for _, field := range []string{"system", "prompt"} {
+			if text, ok := body[field].(string); ok && len(text) > 0 {
+				texts = append(texts, text)
+			}
+		}
+	case "/api/chat", "/v1/chat/completions":
+		messages, _ := body["messages"].([]any)
+		for _, message := range messages {
+			m, _ := message.(map[string]any)
+			switch content := m["content"].(type) {
+			case string:
+				texts = append(texts, content)
+			case []any:
+				// OpenAI compatible content parts
+				for _, part := range content {
+					if p, ok := part.(map[string]any); ok {
+						if text, ok := p["text"].(string); ok {
+							texts = append(texts, text)
+						}
+					}
+				}
+			}
+		}
+	}
+	return strings.Join(texts, "\n")
+}
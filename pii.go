@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// piiPattern detects one kind of personally identifiable information,
+// every candidate of the pattern must also be accepted by the validation in its context.
+type piiPattern struct {
+	kind    string
+	pattern *regexp.Regexp
+	valid   func(text string, start int, end int) bool
+}
+
+// piiPatterns are applied in order, more specific patterns come first
+var piiPatterns = []piiPattern{
+	{"EMAIL", regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`), nil},
+	{"IBAN", regexp.MustCompile(`\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`), nil},
+	{"IP", regexp.MustCompile(`\d{1,3}(?:\.\d{1,3}){3}|(?:[0-9A-Fa-f]{0,4}:){2,7}[0-9A-Fa-f]{0,4}`), isIpAddress},
+	// international numbers, national numbers with trunk prefix and north american numbers,
+	// always with a structure of groups to skip plain numbers
+	{"PHONE", regexp.MustCompile(`\+\d{1,3}(?:[ .-]?(?:\(\d{1,5}\)|\d{1,5})){2,6}|` +
+		`(?:\(0\d{1,5}\)|0\d{1,5})(?:[ /-]\d{2,8}){1,3}|` +
+		`(?:\(\d{3}\) ?|\d{3}[-. ])\d{3}[-.]\d{4}`), isPhoneNumber},
+}
+
+// piiDatePattern matches dates that look like phone numbers
+var piiDatePattern = regexp.MustCompile(`^\d{1,4}[-/.]\d{1,2}[-/.]\d{1,4}$`)
+
+// piiVersionWords are words preceding a dotted number that is a version, not an IP address
+var piiVersionWords = []string{"version", "ver", "v", "release", "build", "rev", "revision"}
+
+// piiPlaceholderPattern matches placeholders created by the PII redactor
+var piiPlaceholderPattern = regexp.MustCompile(`\[(?:EMAIL|IBAN|IP|PHONE)_\d+\]`)
+
+// piiMaxPlaceholderLength is the max length of a placeholder that may be split across response chunks
+const piiMaxPlaceholderLength = 16
+
+// PiiRedactor replaces PII in prompts of a request by stable placeholders and can restore them
+type PiiRedactor struct {
+	placeholders map[string]string
+	originals    map[string]string
+	counters     map[string]int
+}
+
+// NewPiiRedactor will create a new PII redactor for a single request
+func NewPiiRedactor() *PiiRedactor {
+	return &PiiRedactor{
+		placeholders: make(map[string]string),
+		originals:    make(map[string]string),
+		counters:     make(map[string]int),
+	}
+}
+
+// Redact replaces all PII in the given text, the same value always gets the same placeholder
+func (p *PiiRedactor) Redact(text string) string {
+	for _, pii := range piiPatterns {
+		var redacted strings.Builder
+		last := 0
+		for _, match := range pii.pattern.FindAllStringIndex(text, -1) {
+			start, end := match[0], match[1]
+			if pii.valid != nil && !pii.valid(text, start, end) {
+				continue
+			}
+			redacted.WriteString(text[last:start])
+			redacted.WriteString(p.placeholder(pii.kind, text[start:end]))
+			last = end
+		}
+		redacted.WriteString(text[last:])
+		text = redacted.String()
+	}
+	return text
+}
+
+// placeholder returns the placeholder of the given value, the same value always gets the same placeholder
+func (p *PiiRedactor) placeholder(kind string, value string) string {
+	if placeholder, found := p.placeholders[value]; found {
+		return placeholder
+	}
+	p.counters[kind]++
+	placeholder := fmt.Sprintf("[%s_%d]", kind, p.counters[kind])
+	p.placeholders[value] = placeholder
+	p.originals[placeholder] = value
+	return placeholder
+}
+
+// RedactRequest replaces PII in prompts and messages of the given chat/generate request body,
+// of ollama or OpenAI compatible routes, and returns the number of distinct values that got replaced.
+func (p *PiiRedactor) RedactRequest(route string, body map[string]any) int {
+	switch route {
+	case "/api/generate", "/v1/completions":
+		p.redactField(body, "system")
+		p.redactField(body, "prompt")
+	case "/api/chat", "/v1/chat/completions":
+		messages, _ := body["messages"].([]any)
+		for _, message := range messages {
+			if m, ok := message.(map[string]any); ok {
+				p.redactField(m, "content")
+			}
+		}
+	case "/v1/responses":
+		p.redactField(body, "instructions")
+		if input, ok := body["input"].([]any); ok {
+			for _, item := range input {
+				if m, ok := item.(map[string]any); ok {
+					p.redactField(m, "content")
+				}
+			}
+		} else {
+			p.redactField(body, "input")
+		}
+	}
+	return len(p.originals)
+}
+
+// redactField replaces PII in a field holding a text, a list of texts or a list of OpenAI compatible content parts,
+// missing fields and fields of other types are left untouched.
+func (p *PiiRedactor) redactField(object map[string]any, field string) {
+	switch value := object[field].(type) {
+	case string:
+		object[field] = p.Redact(value)
+	case []any:
+		for i, item := range value {
+			switch part := item.(type) {
+			case string:
+				value[i] = p.Redact(part)
+			case map[string]any:
+				if text, ok := part["text"].(string); ok {
+					part["text"] = p.Redact(text)
+				}
+			}
+		}
+	}
+}
+
+// Restore replaces all known placeholders in the given text by their original value
+func (p *PiiRedactor) Restore(text string) string {
+	return piiPlaceholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		if original, found := p.originals[placeholder]; found {
+			return original
+		}
+		return placeholder
+	})
+}
+
+// NewRestorer returns a function that restores placeholders in the text of streamed response chunks,
+// of ollama or OpenAI compatible routes. A placeholder that is split across chunks is held back until it is complete.
+func (p *PiiRedactor) NewRestorer() func(chunk []byte) []byte {
+	pending := ""
+	// flushPending returns the previous chunk carrying the held back text instead of its own text
+	var flushPending func() []byte
+	restoreRaw := func(chunk []byte) []byte {
+		restored := p.restoreRaw(chunk)
+		if len(pending) > 0 && flushPending != nil {
+			restored = append(flushPending(), restored...)
+			pending = ""
+		}
+		return restored
+	}
+	return func(chunk []byte) []byte {
+		payload := bytes.TrimSpace(chunk)
+		prefix := []byte(nil)
+		if after, found := bytes.CutPrefix(payload, []byte("data:")); found {
+			prefix = []byte("data: ")
+			payload = bytes.TrimSpace(after)
+		}
+		var parsed map[string]any
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		if err := decoder.Decode(&parsed); err != nil {
+			return restoreRaw(chunk)
+		}
+		text, setText, done := responseTextField(parsed)
+		if setText == nil {
+			return restoreRaw(chunk)
+		}
+
+		text = pending + text
+		pending = ""
+		if start := strings.LastIndex(text, "["); !done && start >= 0 &&
+			!strings.Contains(text[start:], "]") && len(text)-start < piiMaxPlaceholderLength {
+			pending = text[start:]
+			text = text[:start]
+		}
+		setText(p.Restore(text))
+
+		encode := func() []byte {
+			encoded, err := json.Marshal(parsed)
+			if err != nil {
+				return nil
+			}
+			encoded = append(slices.Clone(prefix), encoded...)
+			if bytes.HasSuffix(chunk, []byte("\n")) {
+				encoded = append(encoded, '\n')
+			}
+			return encoded
+		}
+		restored := encode()
+		if restored == nil {
+			return chunk
+		}
+		flushPending = func() []byte {
+			setText(pending)
+			return encode()
+		}
+		return restored
+	}
+}
+
+// restoreRaw replaces complete placeholders in a chunk of unknown structure by their JSON encoded original value
+func (p *PiiRedactor) restoreRaw(chunk []byte) []byte {
+	return piiPlaceholderPattern.ReplaceAllFunc(chunk, func(placeholder []byte) []byte {
+		original, found := p.originals[string(placeholder)]
+		if !found {
+			return placeholder
+		}
+		encoded, _ := json.Marshal(original)
+		return encoded[1 : len(encoded)-1]
+	})
+}
+
+// responseTextField returns the generated text of a parsed ollama or OpenAI compatible response chunk,
+// a function to replace it and whether the chunk is the final one. The function is nil when the chunk has no text.
+func responseTextField(parsed map[string]any) (text string, setText func(string), done bool) {
+	done, _ = parsed["done"].(bool)
+	if message, ok := parsed["message"].(map[string]any); ok {
+		text, _ = message["content"].(string)
+		return text, func(t string) { message["content"] = t }, done
+	}
+	if response, ok := parsed["response"].(string); ok {
+		return response, func(t string) { parsed["response"] = t }, done
+	}
+	choices, _ := parsed["choices"].([]any)
+	if len(choices) == 0 {
+		return "", nil, done
+	}
+	choice, _ := choices[0].(map[string]any)
+	if choice == nil {
+		return "", nil, done
+	}
+	done = choice["finish_reason"] != nil
+	for _, field := range []string{"delta", "message"} {
+		if message, ok := choice[field].(map[string]any); ok {
+			text, _ = message["content"].(string)
+			return text, func(t string) { message["content"] = t }, done
+		}
+	}
+	if response, ok := choice["text"].(string); ok {
+		return response, func(t string) { choice["text"] = t }, done
+	}
+	return "", nil, done
+}
+
+// isIpAddress checks if a candidate is a valid IP address that isn't part of a longer number, version or identifier
+func isIpAddress(text string, start int, end int) bool {
+	addr, err := netip.ParseAddr(text[start:end])
+	if err != nil || addr.IsUnspecified() {
+		return false
+	}
+	if start > 0 && strings.ContainsRune(".:_", rune(text[start-1])) || start > 0 && isWordByte(text[start-1]) {
+		return false
+	}
+	if end < len(text) && (strings.ContainsRune(":_", rune(text[end])) || isWordByte(text[end]) ||
+		text[end] == '.' && end+1 < len(text) && isDigitByte(text[end+1])) {
+		return false
+	}
+	if addr.Is6() {
+		// short forms like "a::b" are more likely identifiers of source code than addresses
+		groups := slices.DeleteFunc(strings.Split(text[start:end], ":"), func(group string) bool { return len(group) == 0 })
+		if len(groups) < 3 {
+			return false
+		}
+	}
+	if addr.Is4() {
+		fields := strings.Fields(strings.ToLower(text[:start]))
+		if len(fields) > 0 && slices.Contains(piiVersionWords, strings.TrimRight(fields[len(fields)-1], ":")) {
+			return false
+		}
+	}
+	return true
+}
+
+// isPhoneNumber checks if a candidate has the digits of a phone number and isn't part of a date, time or longer number
+func isPhoneNumber(text string, start int, end int) bool {
+	value := text[start:end]
+	if digits := countDigits(value); digits < 7 || digits > 15 || piiDatePattern.MatchString(value) {
+		return false
+	}
+	if start > 0 && (isWordByte(text[start-1]) || strings.ContainsRune(".:,/-+", rune(text[start-1]))) {
+		return false
+	}
+	if end < len(text) && (isWordByte(text[end]) || text[end] == ':' ||
+		strings.ContainsRune("./-,", rune(text[end])) && end+1 < len(text) && isDigitByte(text[end+1])) {
+		return false
+	}
+	return true
+}
+
+// isWordByte checks if the given byte is an ASCII letter or digit
+func isWordByte(c byte) bool {
+	return isDigitByte(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isDigitByte checks if the given byte is an ASCII digit
+func isDigitByte(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// countDigits returns the number of digits in the given text
+func countDigits(text string) int {
+	count := 0
+	for _, c := range text {
+		if c >= '0' && c <= '9' {
+			count++
+		}
+	}
+	return count
+}
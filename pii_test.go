@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPiiRedactorRedacts(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"mail jane.doe@example.com please", "mail [EMAIL_1] please"},
+		{"pay to DE89 3704 0044 0532 0130 00", "pay to [IBAN_1]"},
+		{"call +49 30 1234567 now", "call [PHONE_1] now"},
+		{"call +1 (555) 123-4567", "call [PHONE_1]"},
+		{"call (030) 1234567 or 0171/1234567", "call [PHONE_1] or [PHONE_2]"},
+		{"call 555-123-4567", "call [PHONE_1]"},
+		{"server 192.168.1.20 is down", "server [IP_1] is down"},
+		{"host fe80:0:0:0:204:61ff:fe9d:f156 replied", "host [IP_1] replied"},
+	}
+	for _, tt := range tests {
+		if got := NewPiiRedactor().Redact(tt.text); got != tt.want {
+			t.Errorf("Redact(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestPiiRedactorKeepsOrdinaryText(t *testing.T) {
+	texts := []string{
+		"2026-10-18 16:39:43",
+		"on 18.10.2026 or 10/18/2026",
+		"order 12345678",
+		"pi is 3.14159265",
+		"version 1.2.3.4",
+		"upgrade to v1.2.3.4",
+		"value 1 234 567",
+		"std::vector and a::b",
+		"the ratio 16:9:4",
+		"card 4111 1111 1111 1111 1111",
+	}
+	for _, text := range texts {
+		if got := NewPiiRedactor().Redact(text); got != text {
+			t.Errorf("Redact(%q) = %q, want unchanged", text, got)
+		}
+	}
+}
+
+func TestPiiRedactorStablePlaceholders(t *testing.T) {
+	redactor := NewPiiRedactor()
+
+	got := redactor.Redact("jane@example.com, john@example.com and jane@example.com")
+
+	if want := "[EMAIL_1], [EMAIL_2] and [EMAIL_1]"; got != want {
+		t.Errorf("redacted = %q, want %q", got, want)
+	}
+	if restored := redactor.Restore(got); restored != "jane@example.com, john@example.com and jane@example.com" {
+		t.Errorf("restored = %q", restored)
+	}
+}
+
+func TestPiiRedactorRedactsRoutes(t *testing.T) {
+	tests := []struct {
+		route string
+		body  map[string]any
+		text  func(body map[string]any) string
+	}{
+		{"/api/generate", map[string]any{"prompt": "mail jane@example.com"},
+			func(body map[string]any) string { return body["prompt"].(string) }},
+		{"/api/chat", map[string]any{"messages": []any{map[string]any{"role": "user", "content": "mail jane@example.com"}}},
+			func(body map[string]any) string {
+				return body["messages"].([]any)[0].(map[string]any)["content"].(string)
+			}},
+		{"/v1/chat/completions", map[string]any{"messages": []any{map[string]any{"role": "user", "content": []any{
+			map[string]any{"type": "text", "text": "mail jane@example.com"}}}}},
+			func(body map[string]any) string {
+				content := body["messages"].([]any)[0].(map[string]any)["content"].([]any)
+				return content[0].(map[string]any)["text"].(string)
+			}},
+		{"/v1/completions", map[string]any{"prompt": []any{"mail jane@example.com"}},
+			func(body map[string]any) string { return body["prompt"].([]any)[0].(string) }},
+		{"/v1/responses", map[string]any{"input": "mail jane@example.com"},
+			func(body map[string]any) string { return body["input"].(string) }},
+	}
+	for _, tt := range tests {
+		redactor := NewPiiRedactor()
+		if n := redactor.RedactRequest(tt.route, tt.body); n != 1 {
+			t.Errorf("%s: redacted %d values, want 1", tt.route, n)
+		}
+		if text := tt.text(tt.body); text != "mail [EMAIL_1]" {
+			t.Errorf("%s: text = %q, want redacted", tt.route, text)
+		}
+	}
+}
+
+func TestPiiRestorerJoinsSplitPlaceholders(t *testing.T) {
+	redactor := NewPiiRedactor()
+	redactor.Redact("jane@example.com")
+	restore := redactor.NewRestorer()
+
+	var restored strings.Builder
+	for _, chunk := range []string{
+		`{"message":{"content":"Hello [EMA"},"done":false}` + "\n",
+		`{"message":{"content":"IL_1]!"},"done":false}` + "\n",
+		`{"message":{"content":""},"done":true}` + "\n",
+	} {
+		restored.WriteString(extractResponseText(restore([]byte(chunk))))
+	}
+
+	if restored.String() != "Hello jane@example.com!" {
+		t.Errorf("restored = %q", restored.String())
+	}
+}
+
+func TestPiiRestorerOpenAiStream(t *testing.T) {
+	redactor := NewPiiRedactor()
+	redactor.Redact("jane@example.com")
+	restore := redactor.NewRestorer()
+
+	first := restore([]byte(`data: {"choices":[{"index":0,"delta":{"content":"Hi [EMAIL"},"finish_reason":null}]}` + "\n"))
+	second := restore([]byte(`data: {"choices":[{"index":0,"delta":{"content":"_1]"},"finish_reason":"stop"}]}` + "\n"))
+
+	if !strings.HasPrefix(string(first), "data: ") {
+		t.Errorf("chunk = %q, want server-sent event", first)
+	}
+	if text := extractResponseText(first) + extractResponseText(second); text != "Hi jane@example.com" {
+		t.Errorf("restored = %q", text)
+	}
+	if done := restore([]byte("data: [DONE]\n")); string(done) != "data: [DONE]\n" {
+		t.Errorf("done event = %q, want unchanged", done)
+	}
+}
+
+func TestPiiRedactRequestKeepsMissingFields(t *testing.T) {
+	tests := []struct {
+		route string
+		body  string
+		want  string
+	}{
+		{"/api/generate", `{"model":"m","prompt":"mail jane@example.com"}`, `{"model":"m","prompt":"mail [EMAIL_1]"}`},
+		{"/api/chat", `{"messages":[{"role":"assistant","tool_calls":[]},{"role":"user","content":"hi"}]}`,
+			`{"messages":[{"role":"assistant","tool_calls":[]},{"content":"hi","role":"user"}]}`},
+		{"/v1/responses", `{"input":"hi"}`, `{"input":"hi"}`},
+		{"/api/generate", `{"prompt":null,"system":42}`, `{"prompt":null,"system":42}`},
+	}
+	for _, tt := range tests {
+		var body map[string]any
+		json.Unmarshal([]byte(tt.body), &body)
+		NewPiiRedactor().RedactRequest(tt.route, body)
+		var want map[string]any
+		json.Unmarshal([]byte(tt.want), &want)
+		if !reflect.DeepEqual(body, want) {
+			t.Errorf("%s: body = %v, want %v", tt.route, body, want)
+		}
+	}
+}
+
+func TestPiiRestorerFlushesPendingTextBeforeUndecodableChunk(t *testing.T) {
+	redactor := NewPiiRedactor()
+	restore := redactor.NewRestorer()
+
+	first := restore([]byte(`{"message":{"content":"Hello [EMA"},"done":false}` + "\n"))
+	second := restore([]byte("not json\n"))
+
+	if text := extractResponseText(first); text != "Hello " {
+		t.Errorf("first = %q, want placeholder candidate held back", text)
+	}
+	if !strings.Contains(string(second), `"content":"[EMA"`) || !strings.HasSuffix(string(second), "not json\n") {
+		t.Errorf("second = %q, want held back text followed by the chunk", second)
+	}
+}
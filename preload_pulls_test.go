@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestPreLoadModelsWithBoundedConcurrencyAndRetries(t *testing.T) {
+	var mutex sync.Mutex
+	pulling, maxPulling := 0, 0
+	attempts := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/version":
+			w.Write([]byte(`{"version":"0.9.0"}`))
+		case "/api/tags":
+			w.Write([]byte(`{"models":[]}`))
+		case "/api/pull":
+			var request struct{ Model string }
+			json.NewDecoder(r.Body).Decode(&request)
+			mutex.Lock()
+			pulling++
+			maxPulling = max(maxPulling, pulling)
+			attempts[request.Model]++
+			attempt := attempts[request.Model]
+			mutex.Unlock()
+			time.Sleep(20 * time.Millisecond)
+			mutex.Lock()
+			pulling--
+			mutex.Unlock()
+			switch {
+			case request.Model == "missing":
+				w.Write([]byte(`{"error":"pull model manifest: file does not exist"}` + "\n"))
+			case request.Model == "flaky" && attempt == 1:
+				w.Write([]byte(`{"error":"connection reset"}` + "\n"))
+			default:
+				w.Write([]byte(`{"status":"success"}` + "\n"))
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	upstreamURL, _ := url.Parse(server.URL)
+
+	s := NewServerHandler(NewKeyStore(nil), []string{"qwen3:8b", "flaky", "missing", "gemma3:4b"})
+	s.SetUpstreamURL(upstreamURL)
+	checker := NewHealthChecker(upstreamURL, time.Minute, time.Second, 1)
+	checker.Probe(t.Context())
+	s.SetHealthChecker(checker)
+	s.SetPreloadPulls(PreloadPulls{Concurrency: 2, Retries: 1, RetryBackoff: time.Millisecond})
+
+	s.PreLoadModels(t.Context())
+
+	if maxPulling != 2 {
+		t.Errorf("max concurrent pulls = %d, want 2", maxPulling)
+	}
+	if attempts["flaky"] != 2 || attempts["missing"] != 2 || attempts["qwen3:8b"] != 1 {
+		t.Errorf("attempts = %v, want failed pulls retried once", attempts)
+	}
+	for _, preload := range s.preloads.Models() {
+		want := PreloadReady
+		if preload.Model == "missing" {
+			want = PreloadFailed
+		}
+		if preload.State != want {
+			t.Errorf("preload = %+v, want state %s", preload, want)
+		}
+	}
+}
+
+func TestPreloadPullsRetryDelay(t *testing.T) {
+	pulls := PreloadPulls{RetryBackoff: 5 * time.Second}
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{3, 20 * time.Second},
+		{8, maxPreloadRetryDelay},
+		{100, maxPreloadRetryDelay},
+	}
+	for _, tt := range tests {
+		if delay := pulls.RetryDelay(tt.attempt); delay != tt.delay {
+			t.Errorf("attempt %d: delay = %s, want %s", tt.attempt, delay, tt.delay)
+		}
+	}
+}
+
+func TestPullModelSkipsModelBeingPulled(t *testing.T) {
+	var pulls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pulls.Add(1)
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	}))
+	t.Cleanup(server.Close)
+	upstreamURL, _ := url.Parse(server.URL)
+	s := NewServerHandler(NewKeyStore(nil), []string{"qwen3:8b"})
+	s.SetUpstreamURL(upstreamURL)
+	s.pulls.Start("qwen3:8b")
+
+	err := s.pullModel(t.Context(), api.NewClient(upstreamURL, http.DefaultClient), "qwen3:8b")
+
+	if !errors.Is(err, errPullInProgress) || pulls.Load() != 0 {
+		t.Errorf("err = %v, pulls = %d, want model being pulled skipped", err, pulls.Load())
+	}
+	if progress := s.pulls.Pulls(); len(progress) != 1 || progress[0].Done {
+		t.Errorf("pulls = %+v, want running pull untouched", progress)
+	}
+}
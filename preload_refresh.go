@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// PreloadRefresh configures periodically pulling preloaded models again, to pick up updated tags like "latest"
+type PreloadRefresh struct {
+	// Interval of pulling the models again, zero disables refreshing
+	Interval time.Duration
+	// Unload removes the previous version of an updated model from memory of the upstream,
+	// so that it doesn't keep serving the previous version
+	Unload bool
+}
+
+// SetPreloadRefresh will set whether and how preloaded models get refreshed
+func (s *ServerHandler) SetPreloadRefresh(refresh PreloadRefresh) {
+	s.preloadRefresh = refresh
+	if refresh.Interval > 0 {
+		slog.Info(fmt.Sprintf("Refreshing preloaded models every %s", refresh.Interval))
+	}
+}
+
+// refreshModels pulls the preloaded models that are ready again in the configured interval until the context is done
+func (s *ServerHandler) refreshModels(ctx context.Context, client *api.Client) {
+	ticker := time.NewTicker(s.preloadRefresh.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.isUpstreamRunning() {
+			slog.Warn("Skipped refreshing preloaded models, upstream is unavailable")
+			continue
+		}
+		for _, preload := range s.preloads.Models() {
+			if preload.State != PreloadReady {
+				continue
+			}
+			if err := s.refreshModel(ctx, client, preload.Model); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				slog.Error("Failed to refresh", "model", preload.Model, "error", err)
+			}
+		}
+	}
+}
+
+// refreshModel pulls the model again and compares its digest before and after the pull.
+// When configured, the previous version of an updated model is unloaded after the pull. It can't be addressed
+// by name afterwards, so a loaded previous version is kept under a temporary name while pulling.
+func (s *ServerHandler) refreshModel(ctx context.Context, client *api.Client, model string) error {
+	previousDigest, err := modelDigest(ctx, client, model)
+	if err != nil {
+		return err
+	}
+	previousName := ""
+	if s.preloadRefresh.Unload {
+		if loaded, err := isModelLoaded(ctx, client, model, previousDigest); err != nil {
+			return err
+		} else if loaded {
+			previousName = previousVersionName(model)
+			if err := client.Copy(ctx, &api.CopyRequest{Source: model, Destination: previousName}); err != nil {
+				return fmt.Errorf("keep previous version: %w", err)
+			}
+			defer func() {
+				if err := client.Delete(context.WithoutCancel(ctx), &api.DeleteRequest{Model: previousName}); err != nil {
+					slog.Warn("Failed to delete previous version", "model", previousName, "error", err)
+				}
+			}()
+		}
+	}
+	slog.Info(fmt.Sprintf("Refreshing model %s...", model))
+	if !s.pulls.Start(model) {
+		return errPullInProgress
+	}
+	err = client.Pull(ctx, &api.PullRequest{Model: model}, func(resp api.ProgressResponse) error {
+		s.pulls.Progress(model, resp.Status, resp.Completed, resp.Total)
+		return nil
+	})
+	s.pulls.Finish(model, err)
+	if err != nil {
+		return err
+	}
+	digest, err := modelDigest(ctx, client, model)
+	if err != nil {
+		return err
+	}
+	if digest == previousDigest {
+		slog.Info(fmt.Sprintf("Model %s is up to date", model), "digest", digest)
+		return nil
+	}
+	slog.Info(fmt.Sprintf("Updated model %s", model), "previousDigest", previousDigest, "digest", digest)
+	s.events.Publish(ProxyEvent{
+		Type:           EventModelUpdated,
+		Time:           time.Now(),
+		Model:          model,
+		Digest:         digest,
+		PreviousDigest: previousDigest,
+	})
+	if len(previousName) == 0 {
+		return nil
+	}
+	request := &api.GenerateRequest{Model: previousName, KeepAlive: &api.Duration{Duration: 0}}
+	if err := client.Generate(ctx, request, func(api.GenerateResponse) error { return nil }); err != nil {
+		return fmt.Errorf("unload: %w", err)
+	}
+	if loaded, err := isModelLoaded(ctx, client, model, previousDigest); err != nil {
+		return err
+	} else if loaded {
+		return fmt.Errorf("previous version %s is still loaded", previousDigest)
+	}
+	slog.Info(fmt.Sprintf("Unloaded previous version of model %s", model), "digest", previousDigest)
+	if s.preloadWarmUp.Enabled {
+		if err := s.warmUpModel(ctx, client, model); err != nil {
+			return fmt.Errorf("warm-up: %w", err)
+		}
+		slog.Info(fmt.Sprintf("Warmed up model %s", model))
+	}
+	return nil
+}
+
+// previousVersionName returns the temporary name of the previous version of a refreshed model
+func previousVersionName(model string) string {
+	return withDefaultTag(model) + "-previous"
+}
+
+// isModelLoaded checks if the model with the given digest is loaded into memory of the upstream
+func isModelLoaded(ctx context.Context, client *api.Client, model string, digest string) (bool, error) {
+	running, err := client.ListRunning(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, runningModel := range running.Models {
+		// a model without tag means the "latest" tag
+		if runningModel.Digest == digest && (runningModel.Name == model || runningModel.Name == model+":latest") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// modelDigest returns the digest of the model present at the upstream, empty if the model isn't present
+func modelDigest(ctx context.Context, client *api.Client, model string) (string, error) {
+	tags, err := client.List(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, tag := range tags.Models {
+		// a model without tag means the "latest" tag
+		if tag.Name == model || tag.Name == model+":latest" || tag.Model == model {
+			return tag.Digest, nil
+		}
+	}
+	return "", nil
+}
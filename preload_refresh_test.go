@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+// testRefreshUpstream fakes an upstream whose model gets the pulled digest by the next pull
+type testRefreshUpstream struct {
+	mutex  sync.Mutex
+	digest string
+	pulled string
+	loaded string
+	calls  []string
+}
+
+func (u *testRefreshUpstream) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mutex.Lock()
+	defer u.mutex.Unlock()
+	u.calls = append(u.calls, r.URL.Path)
+	switch r.URL.Path {
+	case "/api/tags":
+		json.NewEncoder(w).Encode(api.ListResponse{Models: []api.ListModelResponse{{Name: "qwen3:latest", Digest: u.digest}}})
+	case "/api/ps":
+		running := api.ProcessResponse{Models: []api.ProcessModelResponse{}}
+		if len(u.loaded) > 0 {
+			running.Models = append(running.Models, api.ProcessModelResponse{Name: "qwen3:latest", Digest: u.loaded})
+		}
+		json.NewEncoder(w).Encode(running)
+	case "/api/pull":
+		u.digest = u.pulled
+		w.Write([]byte(`{"status":"success"}` + "\n"))
+	case "/api/generate":
+		var request api.GenerateRequest
+		json.NewDecoder(r.Body).Decode(&request)
+		if request.Model == "qwen3:latest-previous" {
+			u.loaded = ""
+		}
+		w.Write([]byte(`{"done":true}` + "\n"))
+	}
+}
+
+// refreshTestModel refreshes model "qwen3" of the upstream with unloading enabled
+func refreshTestModel(t *testing.T, upstream http.Handler) error {
+	t.Helper()
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+	upstreamURL, _ := url.Parse(server.URL)
+
+	s := NewServerHandler(NewKeyStore(nil), []string{"qwen3"})
+	s.SetPreloadRefresh(PreloadRefresh{Unload: true})
+	return s.refreshModel(t.Context(), api.NewClient(upstreamURL, http.DefaultClient), "qwen3")
+}
+
+func TestRefreshModelUnloadsPreviousVersionAfterUpdate(t *testing.T) {
+	upstream := &testRefreshUpstream{digest: "old", pulled: "new", loaded: "old"}
+	if err := refreshTestModel(t, upstream); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/api/copy", "/api/pull", "/api/generate", "/api/delete"}
+	if calls := slices.DeleteFunc(upstream.calls, func(call string) bool { return !slices.Contains(want, call) }); !slices.Equal(calls, want) {
+		t.Errorf("calls = %v, want previous version kept during the pull and unloaded afterwards", calls)
+	}
+	if len(upstream.loaded) > 0 {
+		t.Errorf("loaded = %q, want previous version unloaded", upstream.loaded)
+	}
+}
+
+func TestRefreshModelKeepsUpToDateModelLoaded(t *testing.T) {
+	upstream := &testRefreshUpstream{digest: "old", pulled: "old", loaded: "old"}
+	if err := refreshTestModel(t, upstream); err != nil {
+		t.Fatal(err)
+	}
+
+	if slices.Contains(upstream.calls, "/api/generate") || upstream.loaded != "old" {
+		t.Errorf("calls = %v, want model without update kept loaded", upstream.calls)
+	}
+	if !slices.Contains(upstream.calls, "/api/delete") {
+		t.Errorf("calls = %v, want temporary previous version deleted", upstream.calls)
+	}
+}
+
+func TestRefreshModelReportsPreviousVersionStillLoaded(t *testing.T) {
+	upstream := &testRefreshUpstream{digest: "old", pulled: "new", loaded: "old"}
+	err := refreshTestModel(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstream.ServeHTTP(w, r)
+		if r.URL.Path == "/api/generate" {
+			// a request keeps the previous version loaded
+			upstream.mutex.Lock()
+			upstream.loaded = "old"
+			upstream.mutex.Unlock()
+		}
+	}))
+
+	if err == nil {
+		t.Error("previous version still loaded not reported")
+	}
+}
+
+func TestPreviousVersionName(t *testing.T) {
+	tests := map[string]string{
+		"qwen3":                  "qwen3:latest-previous",
+		"qwen3:8b":               "qwen3:8b-previous",
+		"registry:5000/team/llm": "registry:5000/team/llm:latest-previous",
+		"hf.co/org/model:Q4_K_M": "hf.co/org/model:Q4_K_M-previous",
+	}
+	for model, want := range tests {
+		if name := previousVersionName(model); name != want {
+			t.Errorf("%s: name = %q, want %q", model, name, want)
+		}
+	}
+}
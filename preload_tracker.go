@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// PreloadState is the state of preloading a single model
+type PreloadState string
+
+const (
+	PreloadPending PreloadState = "pending"
+	PreloadPulling PreloadState = "pulling"
+	PreloadPulled  PreloadState = "pulled"
+	PreloadWarming PreloadState = "warming"
+	PreloadReady   PreloadState = "ready"
+	PreloadFailed  PreloadState = "failed"
+)
+
+// ModelPreload is the preload status of a single model
+type ModelPreload struct {
+	Model          string       `json:"model"`
+	State          PreloadState `json:"state"`
+	Progress       int          `json:"progress"`
+	CompletedBytes int64        `json:"completed_bytes"`
+	TotalBytes     int64        `json:"total_bytes"`
+	Attempts       int          `json:"attempts"`
+	Error          string       `json:"error,omitempty"`
+	UpdatedAt      time.Time    `json:"updated_at"`
+}
+
+// PreloadProgress is the aggregated progress of preloading all models
+type PreloadProgress struct {
+	Models         int   `json:"models"`
+	Ready          int   `json:"ready"`
+	Failed         int   `json:"failed"`
+	CompletedBytes int64 `json:"completed_bytes"`
+	TotalBytes     int64 `json:"total_bytes"`
+	Progress       int   `json:"progress"`
+}
+
+// PreloadFailurePolicy decides whether failed preloads make the proxy unready
+type PreloadFailurePolicy string
+
+const (
+	// PreloadFailureIgnore never makes the proxy unready
+	PreloadFailureIgnore PreloadFailurePolicy = "ignore"
+	// PreloadFailureAny makes the proxy unready when any model failed
+	PreloadFailureAny PreloadFailurePolicy = "any"
+	// PreloadFailureAll makes the proxy unready when all models failed
+	PreloadFailureAll PreloadFailurePolicy = "all"
+)
+
+// ParsePreloadFailurePolicy returns the policy of the given name, false if the name is unknown
+func ParsePreloadFailurePolicy(name string) (PreloadFailurePolicy, bool) {
+	switch policy := PreloadFailurePolicy(strings.ToLower(strings.TrimSpace(name))); policy {
+	case PreloadFailureIgnore, PreloadFailureAny, PreloadFailureAll:
+		return policy, true
+	}
+	return "", false
+}
+
+// Unready checks whether the number of failed models out of the total makes the proxy unready
+func (p PreloadFailurePolicy) Unready(failed int, total int) bool {
+	switch p {
+	case PreloadFailureAny:
+		return failed > 0
+	case PreloadFailureAll:
+		return failed > 0 && failed == total
+	}
+	return false
+}
+
+// PreloadTracker keeps the preload state of each model,
+// states advance pending -> pulling -> pulled -> warming -> ready, any state may end in failed.
+type PreloadTracker struct {
+	mutex   sync.RWMutex
+	started bool
+	models  []*ModelPreload
+}
+
+// NewPreloadTracker will create a new tracker with all models pending
+func NewPreloadTracker(models []string) *PreloadTracker {
+	t := &PreloadTracker{models: make([]*ModelPreload, 0, len(models))}
+	now := time.Now()
+	for _, model := range models {
+		t.models = append(t.models, &ModelPreload{Model: model, State: PreloadPending, UpdatedAt: now})
+	}
+	return t
+}
+
+// Start records the start of preloading
+func (t *PreloadTracker) Start() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.started = true
+}
+
+// Update records the state of a model, the error is recorded for failed models only
+func (t *PreloadTracker) Update(model string, state PreloadState, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if preload := t.find(model); preload != nil {
+		preload.State = state
+		preload.Error = ""
+		if state == PreloadFailed && err != nil {
+			preload.Error = err.Error()
+		}
+		if state == PreloadPulling {
+			preload.Attempts++
+		}
+		if state == PreloadPulled || state == PreloadReady {
+			preload.Progress = 100
+			preload.CompletedBytes = preload.TotalBytes
+		}
+		preload.UpdatedAt = time.Now()
+	}
+}
+
+// WarmUpFailed records a pulled model whose warm-up failed, the model is ready and keeps the error.
+// Failed warm-ups don't count as failed preloads, ollama loads the model on the first request anyway.
+func (t *PreloadTracker) WarmUpFailed(model string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if preload := t.find(model); preload != nil {
+		preload.State = PreloadReady
+		preload.Error = fmt.Sprintf("warm-up: %s", err)
+		preload.UpdatedAt = time.Now()
+	}
+}
+
+// Retry records a failed attempt of pulling a model that will be retried, the model is pending again
+func (t *PreloadTracker) Retry(model string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if preload := t.find(model); preload != nil {
+		preload.State = PreloadPending
+		preload.Error = err.Error()
+		preload.UpdatedAt = time.Now()
+	}
+}
+
+// Progress records the bytes of a model pulled so far, returns the percentage of the model
+func (t *PreloadTracker) Progress(model string, completed int64, total int64) int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	preload := t.find(model)
+	if preload == nil || total <= 0 {
+		return 0
+	}
+	preload.CompletedBytes = completed
+	preload.TotalBytes = total
+	preload.Progress = int(completed * 100 / total)
+	preload.UpdatedAt = time.Now()
+	return preload.Progress
+}
+
+// Overall returns the aggregated progress of preloading all models, the percentage is the mean of the models
+// because the size of models that aren't pulled yet is unknown. Failed models count as complete.
+func (t *PreloadTracker) Overall() PreloadProgress {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	progress := PreloadProgress{Models: len(t.models)}
+	percentages := 0
+	for _, preload := range t.models {
+		progress.CompletedBytes += preload.CompletedBytes
+		progress.TotalBytes += preload.TotalBytes
+		switch preload.State {
+		case PreloadReady:
+			progress.Ready++
+			percentages += 100
+		case PreloadFailed:
+			progress.Failed++
+			percentages += 100
+		default:
+			percentages += preload.Progress
+		}
+	}
+	if progress.Models > 0 {
+		progress.Progress = percentages / progress.Models
+	}
+	return progress
+}
+
+// find returns the preload status of a model, nil if the model isn't preloaded
+func (t *PreloadTracker) find(model string) *ModelPreload {
+	for _, preload := range t.models {
+		if preload.Model == model {
+			return preload
+		}
+	}
+	return nil
+}
+
+// Status returns the overall status of preloading, it's done when every model is either ready or failed
+func (t *PreloadTracker) Status() PreloadModelStatus {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	if !t.started {
+		return Unknown
+	}
+	for _, preload := range t.models {
+		if preload.State != PreloadReady && preload.State != PreloadFailed {
+			return InProgress
+		}
+	}
+	return Preloaded
+}
+
+// Failed returns the number of failed models and the total number of models
+func (t *PreloadTracker) Failed() (int, int) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	failed := 0
+	for _, preload := range t.models {
+		if preload.State == PreloadFailed {
+			failed++
+		}
+	}
+	return failed, len(t.models)
+}
+
+// Models returns the preload status of all models
+func (t *PreloadTracker) Models() []ModelPreload {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	models := make([]ModelPreload, 0, len(t.models))
+	for _, preload := range t.models {
+		models = append(models, *preload)
+	}
+	return models
+}
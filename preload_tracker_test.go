@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestPreloadTrackerStates(t *testing.T) {
+	tracker := NewPreloadTracker([]string{"qwen3:8b", "gemma3:4b"})
+	if status := tracker.Status(); status != Unknown {
+		t.Errorf("status before start = %s, want unknown", status)
+	}
+
+	tracker.Start()
+	tracker.Update("qwen3:8b", PreloadPulling, nil)
+	if percentage := tracker.Progress("qwen3:8b", 50, 200); percentage != 25 {
+		t.Errorf("progress = %d%%, want 25%%", percentage)
+	}
+	if status := tracker.Status(); status != InProgress {
+		t.Errorf("status while pulling = %s, want in progress", status)
+	}
+
+	tracker.Update("qwen3:8b", PreloadReady, nil)
+	tracker.Update("gemma3:4b", PreloadFailed, errors.New("not found"))
+	if status := tracker.Status(); status != Preloaded {
+		t.Errorf("status = %s, want preloaded", status)
+	}
+	if failed, total := tracker.Failed(); failed != 1 || total != 2 {
+		t.Errorf("failed = %d of %d, want 1 of 2", failed, total)
+	}
+	models := tracker.Models()
+	if models[0].Progress != 100 || models[0].Attempts != 1 || models[1].Error != "not found" {
+		t.Errorf("models = %+v, want pulled model and failed model with error", models)
+	}
+	if overall := tracker.Overall(); overall.Ready != 1 || overall.Failed != 1 || overall.Progress != 100 {
+		t.Errorf("overall = %+v, want preloading complete", overall)
+	}
+}
+
+func TestPreloadTrackerRetry(t *testing.T) {
+	tracker := NewPreloadTracker([]string{"qwen3:8b"})
+	tracker.Update("qwen3:8b", PreloadPulling, nil)
+	tracker.Retry("qwen3:8b", errors.New("connection reset"))
+	tracker.Update("qwen3:8b", PreloadPulling, nil)
+
+	preload := tracker.Models()[0]
+	if preload.State != PreloadPulling || preload.Attempts != 2 || len(preload.Error) > 0 {
+		t.Errorf("preload = %+v, want second attempt without error of first", preload)
+	}
+}
+
+func TestPreloadFailurePolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		failed  int
+		unready bool
+	}{
+		{"any", 0, false},
+		{"any", 1, true},
+		{"all", 1, false},
+		{"all", 2, true},
+		{"ignore", 2, false},
+	}
+	for _, tt := range tests {
+		policy, ok := ParsePreloadFailurePolicy(" " + tt.name + " ")
+		if !ok {
+			t.Fatalf("policy %s unknown", tt.name)
+		}
+		if unready := policy.Unready(tt.failed, 2); unready != tt.unready {
+			t.Errorf("policy %s with %d of 2 failed: unready = %t, want %t", tt.name, tt.failed, unready, tt.unready)
+		}
+	}
+	if _, ok := ParsePreloadFailurePolicy("some"); ok {
+		t.Error("unknown policy accepted")
+	}
+}
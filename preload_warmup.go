@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// PreloadWarmUp configures loading preloaded models into memory of the upstream
+type PreloadWarmUp struct {
+	Enabled bool
+	// KeepAlive is how long the upstream keeps a model in memory after the warm-up, negative keeps it forever,
+	// nil uses the default of the upstream
+	KeepAlive *time.Duration
+	// KeeperInterval is the interval of reloading models that got evicted from memory, zero disables reloading
+	KeeperInterval time.Duration
+}
+
+// SetPreloadWarmUp will set whether and how preloaded models get loaded into memory
+func (s *ServerHandler) SetPreloadWarmUp(warmUp PreloadWarmUp) {
+	s.preloadWarmUp = warmUp
+	if !warmUp.Enabled {
+		return
+	}
+	keepAlive := "default"
+	if warmUp.KeepAlive != nil && *warmUp.KeepAlive < 0 {
+		keepAlive = "forever"
+	} else if warmUp.KeepAlive != nil {
+		keepAlive = warmUp.KeepAlive.String()
+	}
+	slog.Info(fmt.Sprintf("Warming up preloaded models with keep-alive %s", keepAlive))
+	if warmUp.KeeperInterval > 0 {
+		slog.Info(fmt.Sprintf("Reloading evicted preloaded models every %s", warmUp.KeeperInterval))
+	}
+}
+
+// warmUpModel loads the model into memory of the upstream by an empty request,
+// models that don't support generating ( e.g. embedding models ) are loaded by an empty embed request.
+func (s *ServerHandler) warmUpModel(ctx context.Context, client *api.Client, model string) error {
+	var keepAlive *api.Duration
+	if s.preloadWarmUp.KeepAlive != nil {
+		keepAlive = &api.Duration{Duration: *s.preloadWarmUp.KeepAlive}
+	}
+	generateRequest := &api.GenerateRequest{
+		Model:     model,
+		KeepAlive: keepAlive,
+	}
+	err := client.Generate(ctx, generateRequest, func(api.GenerateResponse) error { return nil })
+	if err == nil || ctx.Err() != nil {
+		return err
+	}
+	embedRequest := &api.EmbedRequest{
+		Model:     model,
+		Input:     []string{},
+		KeepAlive: keepAlive,
+	}
+	if _, embedErr := client.Embed(ctx, embedRequest); embedErr != nil {
+		return err
+	}
+	return nil
+}
+
+// keepModelsLoaded reloads preloaded models that got evicted from memory of the upstream in the configured interval,
+// until the context is done. Only models that were ready after preloading are kept loaded.
+func (s *ServerHandler) keepModelsLoaded(ctx context.Context, client *api.Client) {
+	ticker := time.NewTicker(s.preloadWarmUp.KeeperInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if !s.isUpstreamRunning() {
+			continue
+		}
+		running, err := client.ListRunning(ctx)
+		if err != nil {
+			slog.Error("Failed to list loaded models", "error", err)
+			continue
+		}
+		loaded := make(map[string]bool)
+		for _, model := range running.Models {
+			loaded[model.Name] = true
+			loaded[model.Model] = true
+		}
+		for _, preload := range s.preloads.Models() {
+			if preload.State != PreloadReady || loaded[preload.Model] || loaded[preload.Model+":latest"] {
+				continue
+			}
+			slog.Info(fmt.Sprintf("Reloading evicted model %s...", preload.Model))
+			if err := s.warmUpModel(ctx, client, preload.Model); err != nil {
+				slog.Error("Failed to reload", "model", preload.Model, "error", err)
+			} else {
+				slog.Info(fmt.Sprintf("Reloaded model %s", preload.Model))
+			}
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestPreloadWarmUpFailureKeepsModelReady(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/pull" {
+			w.Write([]byte(`{"status":"success"}` + "\n"))
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(`{"error":"out of memory"}`))
+	}))
+	t.Cleanup(upstream.Close)
+	upstreamURL, _ := url.Parse(upstream.URL)
+
+	s := NewServerHandler(NewKeyStore(nil), []string{"qwen3:8b"})
+	s.SetPreloadFailurePolicy(PreloadFailureAny)
+	s.SetPreloadWarmUp(PreloadWarmUp{Enabled: true})
+	s.preloads.Start()
+	s.preloadModel(t.Context(), api.NewClient(upstreamURL, http.DefaultClient), "qwen3:8b")
+
+	preload := s.preloads.Models()[0]
+	if preload.State != PreloadReady || !strings.HasPrefix(preload.Error, "warm-up:") {
+		t.Errorf("preload = %+v, want ready model with warm-up error", preload)
+	}
+	if failed, total := s.preloads.Failed(); s.preloadFailurePolicy.Unready(failed, total) {
+		t.Errorf("%d of %d preloads failed, want failed warm-up not counted", failed, total)
+	}
+}
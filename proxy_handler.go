@@ -2,12 +2,14 @@ package main
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
 	"io"
 	"log/slog"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
@@ -17,6 +19,7 @@ import (
 type UserModelMetrics struct {
 	CreatedAt time.Time `json:"created_at"`
 	Model     string    `json:"model"`
+	KeyName   string    `json:"key_name,omitempty"`
 	UserId    string    `json:"user_id,omitempty"`
 	UserName  string    `json:"user_name,omitempty"`
 	api.Metrics
@@ -30,10 +33,61 @@ type ProxyHandler struct {
 	userModelMetricsCallback func(userModelMetrics UserModelMetrics)
 	userId                   string
 	userName                 string
+	rewritePolicy            *RewritePolicy
+	rewrites                 []string
+	modelAliases             ModelAliases
+	reverseModelAlias        func(chunk []byte) []byte
+	responseCache            *ResponseCache
+	cacheKey                 string
+	cacheHit                 bool
+	requestCoalescer         *RequestCoalescer
+	coalesceKey              string
+	coalesced                bool
+	requestId                string
+	identity                 *Identity
+	auditLog                 *AuditLog
+	auditRecord              *AuditRecord
+	moderation               *Moderation
+	moderationFlag           string
+	piiRedaction             bool
+	piiRestore               bool
+	restorePii               func(chunk []byte) []byte
+	events                   *EventBus
+	event                    ProxyEvent
 }
 
 func (t *ProxyHandler) RoundTrip(request *http.Request) (*http.Response, error) {
-	return http.DefaultTransport.RoundTrip(request)
+	if len(t.cacheKey) > 0 {
+		if cached, found := t.responseCache.Get(t.cacheKey); found {
+			t.logger.Info("Serving cached response", "cacheKey", t.cacheKey)
+			t.cacheHit = true
+			response := cached.Response(request)
+			response.Header.Set("X-Cache", "HIT")
+			return response, nil
+		}
+	}
+	var response *http.Response
+	var err error
+	if len(t.coalesceKey) > 0 {
+		var shared bool
+		response, shared, err = t.requestCoalescer.Do(t.coalesceKey, request, http.DefaultTransport.RoundTrip)
+		if shared {
+			t.logger.Info("Sharing response of identical in-flight request")
+			t.coalesced = true
+			if err == nil {
+				response.Header.Set("X-Coalesced", "true")
+			}
+		}
+	} else {
+		response, err = http.DefaultTransport.RoundTrip(request)
+	}
+	if err == nil && len(t.cacheKey) > 0 && !t.coalesced {
+		response.Header.Set("X-Cache", "MISS")
+		if response.StatusCode == http.StatusOK {
+			response.Body = t.responseCache.Record(t.cacheKey, response)
+		}
+	}
+	return response, err
 }
 
 func (h *ProxyHandler) ProxyRequest(w http.ResponseWriter, r *http.Request) {
@@ -52,23 +106,257 @@ func (h *ProxyHandler) rewrite(r *httputil.ProxyRequest) {
 			h.userName = strings.TrimSpace(r.In.Header.Get(key))
 		}
 	}
+	// the user of a token based identity can't be overridden by request headers
+	if h.identity != nil && len(h.identity.UserId) > 0 {
+		h.userId = h.identity.UserId
+		h.userName = h.identity.UserName
+	}
+
+	h.rewriteBody(r.Out)
+
+	if h.responseCache != nil {
+		h.cacheKey = h.responseCache.Key(r.Out)
+	}
+	if h.requestCoalescer != nil {
+		h.coalesceKey = h.requestCoalescer.Key(r.Out)
+	}
+	if h.auditLog != nil {
+		h.startAuditRecord(r)
+	}
+}
+
+// SetRequestContext will set the id of the request and the identity of the caller
+func (h *ProxyHandler) SetRequestContext(requestId string, identity *Identity) {
+	h.requestId = requestId
+	h.identity = identity
+}
+
+// SetAuditLog will set the audit log receiving prompts and completions of the request
+func (h *ProxyHandler) SetAuditLog(auditLog *AuditLog) {
+	h.auditLog = auditLog
+}
+
+// SetModeration will set the moderation of the request, a non-empty flag
+// indicates that the prompt was flagged but the request was accepted anyway.
+func (h *ProxyHandler) SetModeration(moderation *Moderation, flag string) {
+	h.moderation = moderation
+	h.moderationFlag = flag
+}
+
+// SetEventBus will set the bus receiving the upstream events of the request, the given event describes the request
+func (h *ProxyHandler) SetEventBus(events *EventBus, event ProxyEvent) {
+	h.events = events
+	h.event = event
+}
+
+// startAuditRecord captures the details of the outgoing request for the audit log
+func (h *ProxyHandler) startAuditRecord(r *httputil.ProxyRequest) {
+	data, err := readRequestBody(r.Out)
+	if err != nil {
+		h.logger.Error("Failed to read request body for audit log", "error", err)
+	}
+	h.auditRecord = newAuditRecord(r.In, h.requestId, h.identity, data)
+	h.auditRecord.UserId = h.userId
+	h.auditRecord.UserName = h.userName
+}
+
+// finishAuditRecord writes the audit record of the request including the reassembled completion
+// or the error of a failed upstream call
+func (h *ProxyHandler) finishAuditRecord(status int, completion string, truncated bool, errorText string) {
+	record := *h.auditRecord
+	record.Status = status
+	record.DurationMs = time.Since(record.Time).Milliseconds()
+	record.Response = completion
+	record.Truncated = truncated
+	record.Error = errorText
+	if err := h.auditLog.Log(record); err != nil {
+		h.logger.Error("Failed to write audit log", "error", err)
+	}
+}
+
+// handleError replies with status 502 when the upstream call failed
+func (h *ProxyHandler) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	h.logger.Error("Failed to proxy request", "error", err)
+	if h.auditRecord != nil {
+		h.finishAuditRecord(http.StatusBadGateway, "", false, err.Error())
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// SetRewritePolicy will set the policy used to rewrite ollama options of the request
+func (h *ProxyHandler) SetRewritePolicy(policy *RewritePolicy) {
+	h.rewritePolicy = policy
+}
+
+// SetModelAliases will set the aliases used to map virtual to concrete model names
+func (h *ProxyHandler) SetModelAliases(aliases ModelAliases) {
+	h.modelAliases = aliases
+}
+
+// SetResponseCache will set the cache used for responses of deterministic requests
+func (h *ProxyHandler) SetResponseCache(cache *ResponseCache) {
+	h.responseCache = cache
+}
+
+// SetRequestCoalescer will set the coalescer used to share identical in-flight requests
+func (h *ProxyHandler) SetRequestCoalescer(coalescer *RequestCoalescer) {
+	h.requestCoalescer = coalescer
+}
+
+// SetPiiRedaction will set whether PII in prompts gets replaced by placeholders
+// and whether the placeholders get restored in the response.
+func (h *ProxyHandler) SetPiiRedaction(enabled bool, restore bool) {
+	h.piiRedaction = enabled
+	h.piiRestore = restore
+}
+
+// rewriteBody applies model aliases, the rewrite policy and PII redaction to the JSON body of the outgoing request
+func (h *ProxyHandler) rewriteBody(r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		return
+	}
+	if h.rewritePolicy.IsEmpty() && len(h.modelAliases) == 0 && !h.piiRedaction {
+		return
+	}
+	data, err := readRequestBody(r)
+	if err != nil {
+		h.logger.Error("Failed to read request body", "error", err)
+		return
+	}
+	body := decodeJsonObject(data)
+	if body == nil {
+		return
+	}
+	alias, model := h.modelAliases.ResolveRequest(body)
+	if len(alias) > 0 {
+		h.logger.Info("Resolved model alias", "alias", alias, "model", model)
+		h.reverseModelAlias = newModelAliasReverser(alias, model)
+	}
+	h.rewrites = h.rewritePolicy.Apply(r.URL.Path, body)
+	redacted := 0
+	if h.piiRedaction {
+		redactor := NewPiiRedactor()
+		if redacted = redactor.RedactRequest(r.URL.Path, body); redacted > 0 {
+			h.logger.Info("Redacted PII in request", "values", redacted)
+			if h.piiRestore {
+				h.restorePii = redactor.NewRestorer()
+			}
+		}
+	}
+	if len(h.rewrites) == 0 && len(alias) == 0 && redacted == 0 {
+		return
+	}
+	data, err = json.Marshal(body)
+	if err != nil {
+		h.logger.Error("Failed to encode rewritten request body", "error", err)
+		h.rewrites = nil
+		return
+	}
+	setRequestBody(r, data)
+	if len(h.rewrites) > 0 {
+		h.logger.Info("Rewrote request", "changes", h.rewrites)
+	}
+}
+
+// addVirtualModels adds the model aliases to a "/api/tags" response
+func (h *ProxyHandler) addVirtualModels(response *http.Response) {
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err == nil {
+		var tags []byte
+		if tags, err = h.modelAliases.AddVirtualModels(data); err == nil {
+			data = tags
+		}
+	}
+	if err != nil {
+		h.logger.Error("Failed to add virtual models", "error", err)
+	}
+	response.Body = io.NopCloser(bytes.NewReader(data))
+	response.ContentLength = int64(len(data))
+	response.Header.Set("Content-Length", strconv.Itoa(len(data)))
 }
 
 func (h *ProxyHandler) modifyResponse(response *http.Response) error {
 	h.logger.Info("Got backend response", "status", response.StatusCode)
+	if h.events != nil {
+		event := h.event.as(EventUpstream, response.Header.Get("X-Cache"))
+		event.Status = response.StatusCode
+		h.events.Publish(event)
+	}
+	if len(h.rewrites) > 0 {
+		response.Header.Set("X-Proxy-Rewritten", strings.Join(h.rewrites, "; "))
+	}
+	if len(h.modelAliases) > 0 && response.Request.Method == http.MethodGet &&
+		response.Request.URL.Path == "/api/tags" && response.StatusCode == http.StatusOK {
+		h.addVirtualModels(response)
+		if h.auditRecord != nil {
+			h.finishAuditRecord(response.StatusCode, "", false, "")
+		}
+		return nil
+	}
+	if len(h.moderationFlag) > 0 {
+		response.Header.Set("X-Moderation-Flagged", "prompt")
+	}
+	scanOutput := h.moderation != nil && h.moderation.ScanOutput && response.StatusCode == http.StatusOK
+	if h.reverseModelAlias != nil || h.restorePii != nil || scanOutput {
+		// reverse mapping of the model alias, restoring PII or terminating a moderated output changes the size of the body
+		response.ContentLength = -1
+		response.Header.Del("Content-Length")
+	}
 	pr, pw := io.Pipe()
 	body := response.Body
 	response.Body = pr
 	go func() {
 		defer pw.Close()
 		totalSize := 0
+		var completion strings.Builder
+		completionTruncated := false
+		if h.auditRecord != nil {
+			defer func() {
+				h.finishAuditRecord(response.StatusCode, completion.String(), completionTruncated, "")
+			}()
+		}
+		var scanner *outputScanner
+		if scanOutput {
+			scanner = &outputScanner{moderator: h.moderation.Moderator}
+		}
 		reader := bufio.NewReader(body)
 		for {
 			chunk, lineErr := reader.ReadBytes('\n')
 			h.logger.Debug("Got", "chunk", chunk)
 			chunkSize := len(chunk)
 			totalSize += chunkSize
-			if _, err := pw.Write(chunk); err != nil {
+			outChunk := chunk
+			if h.reverseModelAlias != nil {
+				outChunk = h.reverseModelAlias(outChunk)
+			}
+			if h.restorePii != nil {
+				outChunk = h.restorePii(outChunk)
+			}
+			if h.auditRecord != nil && !completionTruncated {
+				completion.WriteString(extractResponseText(chunk))
+				completionTruncated = completion.Len() > h.auditLog.MaxBodySize()
+			}
+			if scanner != nil {
+				result, err := scanner.Scan(response.Request.Context(), extractResponseText(chunk), lineErr != nil)
+				if err != nil && h.moderation.FailClosed {
+					h.logger.Error("Terminated response, failed to moderate output", "error", err)
+					pw.Write([]byte("{\"error\":\"Response terminated, content moderation failed\"}\n"))
+					body.Close()
+					return
+				} else if err != nil {
+					h.logger.Error("Failed to moderate output", "error", err)
+				} else if result.Flagged && h.moderation.Action == ModerationBlock {
+					h.logger.Warn("Terminated response by content moderation", "reason", result.Reason)
+					pw.Write([]byte("{\"error\":\"Response terminated by content moderation\"}\n"))
+					body.Close()
+					return
+				} else if result.Flagged {
+					h.logger.Warn("Flagged response by content moderation", "reason", result.Reason)
+					scanner = nil
+				}
+			}
+			if _, err := pw.Write(outChunk); err != nil {
 				h.logger.Info("Failed to write", "error", err)
 			}
 			if lineErr == io.EOF {
@@ -79,9 +367,16 @@ func (h *ProxyHandler) modifyResponse(response *http.Response) error {
 				h.logger.Error("Failed backend response", "error", lineErr, "bodySize", totalSize)
 				return
 			}
-			if h.userModelMetricsCallback != nil {
+			forwardMetrics := h.userModelMetricsCallback != nil && !h.cacheHit && !h.coalesced
+			publishMetrics := h.events != nil && h.events.HasSubscribers()
+			if forwardMetrics || publishMetrics {
 				chatResponse := extractDoneChatResponse(chunk)
-				if chatResponse != nil {
+				if chatResponse != nil && publishMetrics {
+					event := h.event.as(EventMetrics, "")
+					event.Metrics = &chatResponse.Metrics
+					h.events.Publish(event)
+				}
+				if chatResponse != nil && forwardMetrics {
 					userModelMetrics := UserModelMetrics{
 						CreatedAt: chatResponse.CreatedAt,
 						Model:     chatResponse.Model,
@@ -89,6 +384,9 @@ func (h *ProxyHandler) modifyResponse(response *http.Response) error {
 						UserName:  h.userName,
 						Metrics:   chatResponse.Metrics,
 					}
+					if h.identity != nil {
+						userModelMetrics.KeyName = h.identity.Name
+					}
 					go h.userModelMetricsCallback(userModelMetrics)
 				}
 			}
@@ -104,6 +402,7 @@ func NewProxyHandler(upstreamURL *url.URL, userModelMetricsCallback func(metrics
 	ph.Proxy.Transport = ph
 	ph.Proxy.Rewrite = ph.rewrite
 	ph.Proxy.ModifyResponse = ph.modifyResponse
+	ph.Proxy.ErrorHandler = ph.handleError
 	ph.logger = logger
 	ph.upstreamURL = upstreamURL
 	ph.userModelMetricsCallback = userModelMetricsCallback
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PullProgress is the progress of pulling a model
+type PullProgress struct {
+	Model      string     `json:"model"`
+	Status     string     `json:"status"`
+	Completed  int64      `json:"completed"`
+	Total      int64      `json:"total"`
+	Error      string     `json:"error,omitempty"`
+	Done       bool       `json:"done"`
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// PullTracker keeps the progress of model pulls, started by preloading or the admin API
+type PullTracker struct {
+	mutex sync.Mutex
+	pulls map[string]*PullProgress
+}
+
+// NewPullTracker will create a new pull tracker
+func NewPullTracker() *PullTracker {
+	return &PullTracker{
+		pulls: make(map[string]*PullProgress),
+	}
+}
+
+// Start records the start of pulling a model, returns false when the model is already being pulled
+func (t *PullTracker) Start(model string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if pull, found := t.pulls[model]; found && !pull.Done {
+		return false
+	}
+	t.pulls[model] = &PullProgress{Model: model, Status: "starting", StartedAt: time.Now()}
+	return true
+}
+
+// Progress records the progress of pulling a model
+func (t *PullTracker) Progress(model string, status string, completed int64, total int64) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if pull, found := t.pulls[model]; found {
+		pull.Status = status
+		pull.Completed = completed
+		pull.Total = total
+	}
+}
+
+// Finish records the end of pulling a model
+func (t *PullTracker) Finish(model string, err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if pull, found := t.pulls[model]; found {
+		now := time.Now()
+		pull.Done = true
+		pull.FinishedAt = &now
+		if err != nil {
+			pull.Status = "failed"
+			pull.Error = err.Error()
+		} else {
+			pull.Status = "success"
+		}
+	}
+}
+
+// Pulls returns the progress of all pulls, most recent first
+func (t *PullTracker) Pulls() []PullProgress {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	pulls := make([]PullProgress, 0, len(t.pulls))
+	for _, pull := range t.pulls {
+		pulls = append(pulls, *pull)
+	}
+	sort.Slice(pulls, func(i, j int) bool { return pulls[i].StartedAt.After(pulls[j].StartedAt) })
+	return pulls
+}
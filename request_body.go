@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// readRequestBody reads the complete body of the request and
+// replaces it with a fresh reader, so that it can be read again.
+func readRequestBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	data, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	if err != nil {
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		return nil, err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	return data, nil
+}
+
+// setRequestBody replaces the body of the request with the given data
+func setRequestBody(r *http.Request, data []byte) {
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	r.ContentLength = int64(len(data))
+	r.TransferEncoding = nil
+	r.Header.Set("Content-Length", strconv.Itoa(len(data)))
+}
+
+// decodeJsonObject decodes data as JSON object, returns nil if data isn't a JSON object
+func decodeJsonObject(data []byte) map[string]any {
+	if len(data) == 0 {
+		return nil
+	}
+	var object map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&object); err != nil {
+		return nil
+	}
+	return object
+}
+
+// requestModel returns the model named in the JSON body of a POST request, empty when there is none
+func requestModel(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	data, err := readRequestBody(r)
+	if err != nil {
+		return ""
+	}
+	body := decodeJsonObject(data)
+	for _, field := range modelFields {
+		if model, ok := body[field].(string); ok && len(model) > 0 {
+			return model
+		}
+	}
+	return ""
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+)
+
+// coalescedBufferSize is the number of buffered response bytes of a shared call, beyond that
+// no further requests join the call and bytes read by every client get dropped
+const coalescedBufferSize = 4 * 1024 * 1024
+
+// RequestCoalescer lets identical concurrent idempotent requests share one upstream call
+type RequestCoalescer struct {
+	mutex      sync.Mutex
+	calls      map[string]*coalescedCall
+	bufferSize int
+}
+
+// coalescedCall is an upstream call shared by all identical requests,
+// the response body gets buffered and fanned out to every waiting client.
+type coalescedCall struct {
+	ready      chan struct{}
+	statusCode int
+	header     http.Header
+	err        error
+	cancel     context.CancelFunc
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	body    []byte
+	base    int
+	done    bool
+	bodyErr error
+	readers int
+	open    map[*coalescedReader]struct{}
+}
+
+// NewRequestCoalescer will create a new request coalescer
+func NewRequestCoalescer() *RequestCoalescer {
+	return &RequestCoalescer{
+		calls:      make(map[string]*coalescedCall),
+		bufferSize: coalescedBufferSize,
+	}
+}
+
+// Key returns the coalescing key of the given request, or an empty string
+// when the request is not idempotent and must not be coalesced.
+func (c *RequestCoalescer) Key(r *http.Request) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return hex.EncodeToString(hash.Sum(nil))
+	case http.MethodPost:
+	default:
+		return ""
+	}
+	data, err := readRequestBody(r)
+	if err != nil {
+		return ""
+	}
+	switch r.URL.Path {
+	case "/api/embed", "/api/embeddings", "/api/show":
+	case "/api/generate", "/api/chat":
+		body := decodeJsonObject(data)
+		if body == nil || !isDeterministicGeneration(body) {
+			return ""
+		}
+	default:
+		return ""
+	}
+	hash.Write(data)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Do executes the request via roundTrip, unless an identical request is already in-flight.
+// In that case the response of the in-flight request is shared, indicated by the returned flag.
+func (c *RequestCoalescer) Do(key string, request *http.Request, roundTrip func(*http.Request) (*http.Response, error)) (*http.Response, bool, error) {
+	c.mutex.Lock()
+	call, shared := c.calls[key]
+	var upstreamRequest *http.Request
+	if !shared {
+		// the upstream call must outlive the client that started it, as long as other clients wait for it
+		ctx, cancel := context.WithCancel(context.WithoutCancel(request.Context()))
+		call = &coalescedCall{ready: make(chan struct{}), cancel: cancel, open: make(map[*coalescedReader]struct{})}
+		call.cond = sync.NewCond(&call.mutex)
+		c.calls[key] = call
+		upstreamRequest = request.WithContext(ctx)
+	}
+	call.mutex.Lock()
+	call.readers++
+	call.mutex.Unlock()
+	c.mutex.Unlock()
+
+	if !shared {
+		go c.run(key, call, upstreamRequest, roundTrip)
+	}
+
+	select {
+	case <-call.ready:
+	case <-request.Context().Done():
+		call.release()
+		return nil, shared, request.Context().Err()
+	}
+	if call.err != nil {
+		call.release()
+		return nil, shared, call.err
+	}
+
+	response := &http.Response{
+		Status:        http.StatusText(call.statusCode),
+		StatusCode:    call.statusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        call.header.Clone(),
+		Body:          call.newReader(request.Context()),
+		ContentLength: -1,
+		Request:       request,
+	}
+	return response, shared, nil
+}
+
+// run performs the upstream call and buffers its response body
+func (c *RequestCoalescer) run(key string, call *coalescedCall, request *http.Request, roundTrip func(*http.Request) (*http.Response, error)) {
+	forgotten := false
+	forget := func() {
+		if !forgotten {
+			forgotten = true
+			c.mutex.Lock()
+			delete(c.calls, key)
+			c.mutex.Unlock()
+		}
+	}
+	defer func() {
+		forget()
+		call.cancel()
+	}()
+
+	response, err := roundTrip(request)
+	if err != nil {
+		call.err = err
+		close(call.ready)
+		return
+	}
+	defer response.Body.Close()
+	call.statusCode = response.StatusCode
+	call.header = response.Header.Clone()
+	call.header.Del("Content-Length")
+	close(call.ready)
+
+	buffer := make([]byte, 32*1024)
+	for {
+		n, readErr := response.Body.Read(buffer)
+		// only this goroutine modifies the body
+		overflow := len(call.body)+n > c.bufferSize
+		if overflow {
+			// late requests can't join anymore, they would miss the dropped bytes
+			forget()
+		}
+		call.mutex.Lock()
+		call.body = append(call.body, buffer[:n]...)
+		if overflow {
+			call.compact()
+		}
+		if readErr != nil {
+			call.done = true
+			if readErr != io.EOF {
+				call.bodyErr = readErr
+			}
+		}
+		call.cond.Broadcast()
+		call.mutex.Unlock()
+		if readErr != nil {
+			if readErr != io.EOF {
+				slog.Error("Failed to read coalesced response", "error", readErr)
+			}
+			return
+		}
+	}
+}
+
+// compact drops the buffered bytes every client has read already, the mutex must be held.
+// Clients that joined but didn't start reading yet need the whole body.
+func (call *coalescedCall) compact() {
+	if len(call.open) < call.readers {
+		return
+	}
+	consumed := call.base + len(call.body)
+	for reader := range call.open {
+		consumed = min(consumed, reader.offset)
+	}
+	call.body = append(call.body[:0], call.body[consumed-call.base:]...)
+	call.base = consumed
+}
+
+// release drops a reader of the call, the upstream call gets cancelled when nobody is waiting anymore
+func (call *coalescedCall) release() {
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	call.readers--
+	if call.readers == 0 && !call.done {
+		call.cancel()
+	}
+}
+
+// newReader returns a reader of the response body, that stops waiting for the body when the context is done
+func (call *coalescedCall) newReader(ctx context.Context) *coalescedReader {
+	reader := &coalescedReader{call: call, ctx: ctx}
+	reader.stop = context.AfterFunc(ctx, func() {
+		call.mutex.Lock()
+		defer call.mutex.Unlock()
+		call.cond.Broadcast()
+	})
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	call.open[reader] = struct{}{}
+	return reader
+}
+
+// coalescedReader reads the buffered response body of a shared upstream call
+type coalescedReader struct {
+	call *coalescedCall
+	ctx  context.Context
+	stop func() bool
+	// offset is the position in the whole response body
+	offset int
+	closed bool
+}
+
+func (r *coalescedReader) Read(p []byte) (int, error) {
+	call := r.call
+	call.mutex.Lock()
+	defer call.mutex.Unlock()
+	for r.offset >= call.base+len(call.body) && !call.done && r.ctx.Err() == nil {
+		call.cond.Wait()
+	}
+	if r.offset < call.base+len(call.body) {
+		n := copy(p, call.body[r.offset-call.base:])
+		r.offset += n
+		return n, nil
+	}
+	if err := r.ctx.Err(); err != nil && !call.done {
+		return 0, err
+	}
+	if call.bodyErr != nil {
+		return 0, call.bodyErr
+	}
+	return 0, io.EOF
+}
+
+func (r *coalescedReader) Close() error {
+	if !r.closed {
+		r.closed = true
+		r.stop()
+		r.call.mutex.Lock()
+		delete(r.call.open, r)
+		r.call.mutex.Unlock()
+		r.call.release()
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRequestCoalescerKey(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+	tests := []struct {
+		name      string
+		method    string
+		route     string
+		body      string
+		coalesced bool
+	}{
+		{"get request", http.MethodGet, "/api/tags", ``, true},
+		{"embedding", http.MethodPost, "/api/embed", `{"model":"nomic","input":"hi"}`, true},
+		{"deterministic generation", http.MethodPost, "/api/chat", `{"model":"llama3","options":{"temperature":0,"seed":1}}`, true},
+		{"sampled generation", http.MethodPost, "/api/chat", `{"model":"llama3"}`, false},
+		{"pull", http.MethodPost, "/api/pull", `{"model":"llama3"}`, false},
+		{"delete", http.MethodDelete, "/api/delete", `{"model":"llama3"}`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.route, strings.NewReader(tt.body))
+			if key := coalescer.Key(r); (len(key) > 0) != tt.coalesced {
+				t.Errorf("key = %q, want coalesced %t", key, tt.coalesced)
+			}
+		})
+	}
+}
+
+func TestRequestCoalescerSharesInFlightCall(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+	release := make(chan struct{})
+	var calls atomic.Int32
+	roundTrip := func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		<-release
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Header:     http.Header{"Content-Type": {"application/json"}},
+			Body:       io.NopCloser(strings.NewReader(`{"models":[]}`)),
+		}, nil
+	}
+
+	const clients = 5
+	var wg sync.WaitGroup
+	bodies := make([]string, clients)
+	shared := make([]bool, clients)
+	for i := range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+			response, isShared, err := coalescer.Do("key", r, roundTrip)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer response.Body.Close()
+			data, _ := io.ReadAll(response.Body)
+			bodies[i], shared[i] = string(data), isShared
+		}()
+	}
+	// give every client the chance to join the in-flight call
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("upstream calls = %d, want 1", n)
+	}
+	sharedCount := 0
+	for i := range clients {
+		if bodies[i] != `{"models":[]}` {
+			t.Errorf("client %d got body %q", i, bodies[i])
+		}
+		if shared[i] {
+			sharedCount++
+		}
+	}
+	if sharedCount != clients-1 {
+		t.Errorf("shared responses = %d, want %d", sharedCount, clients-1)
+	}
+}
+
+func TestRequestCoalescerForgetsFinishedCall(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+	var calls atomic.Int32
+	roundTrip := func(r *http.Request) (*http.Response, error) {
+		calls.Add(1)
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+	}
+
+	for range 2 {
+		response, _, err := coalescer.Do("key", httptest.NewRequest(http.MethodGet, "/api/tags", nil), roundTrip)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.ReadAll(response.Body)
+		response.Body.Close()
+		// the call is forgotten by the goroutine reading the upstream body
+		for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+			coalescer.mutex.Lock()
+			pending := len(coalescer.calls)
+			coalescer.mutex.Unlock()
+			if pending == 0 {
+				break
+			}
+		}
+	}
+
+	if n := calls.Load(); n != 2 {
+		t.Errorf("upstream calls = %d, want 2 for sequential requests", n)
+	}
+}
+
+func TestRequestCoalescerCancelsCallOfDisconnectedClient(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+	upstreamDone := make(chan struct{})
+	roundTrip := func(r *http.Request) (*http.Response, error) {
+		body, writer := io.Pipe()
+		go func() {
+			writer.Write([]byte(`{"response":"a"}`))
+			<-r.Context().Done()
+			writer.CloseWithError(r.Context().Err())
+			close(upstreamDone)
+		}()
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	response, _, err := coalescer.Do("key", httptest.NewRequest(http.MethodPost, "/api/chat", nil).WithContext(ctx), roundTrip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buffer := make([]byte, 64)
+	if _, err := response.Body.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+	go cancel()
+	if _, err := response.Body.Read(buffer); err == nil {
+		t.Error("read of disconnected client kept waiting for the stream")
+	}
+	response.Body.Close()
+
+	select {
+	case <-upstreamDone:
+	case <-time.After(5 * time.Second):
+		t.Error("upstream call not cancelled")
+	}
+}
+
+func TestRequestCoalescerStopsSharingLargeResponses(t *testing.T) {
+	coalescer := NewRequestCoalescer()
+	coalescer.bufferSize = 8
+	body, writer := io.Pipe()
+	var calls atomic.Int32
+	roundTrip := func(r *http.Request) (*http.Response, error) {
+		if calls.Add(1) > 1 {
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("ok"))}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: body}, nil
+	}
+
+	response, _, err := coalescer.Do("key", httptest.NewRequest(http.MethodGet, "/api/tags", nil), roundTrip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	go writer.Write([]byte("0123456789"))
+	data := make([]byte, 10)
+	if _, err := io.ReadFull(response.Body, data); err != nil || string(data) != "0123456789" {
+		t.Fatalf("body = %q, err = %v", data, err)
+	}
+
+	late, shared, err := coalescer.Do("key", httptest.NewRequest(http.MethodGet, "/api/tags", nil), roundTrip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	late.Body.Close()
+	if shared {
+		t.Error("request joined call exceeding the buffer size")
+	}
+
+	writer.Close()
+	if rest, _ := io.ReadAll(response.Body); len(rest) != 0 {
+		t.Errorf("rest = %q, want end of body", rest)
+	}
+}
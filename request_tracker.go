@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// ActiveRequest is a request that is currently proxied
+type ActiveRequest struct {
+	RequestId string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Model     string    `json:"model,omitempty"`
+	KeyName   string    `json:"key_name,omitempty"`
+	UserName  string    `json:"user_name,omitempty"`
+	ClientIP  string    `json:"client_ip"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// RequestTracker keeps the requests that are currently proxied
+type RequestTracker struct {
+	mutex    sync.Mutex
+	requests map[string]ActiveRequest
+}
+
+// NewRequestTracker will create a new request tracker
+func NewRequestTracker() *RequestTracker {
+	return &RequestTracker{
+		requests: make(map[string]ActiveRequest),
+	}
+}
+
+// Start records the start of a request and returns the function to call when the request is done
+func (t *RequestTracker) Start(request ActiveRequest) func() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.requests[request.RequestId] = request
+	return func() {
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		delete(t.requests, request.RequestId)
+	}
+}
+
+// Len returns the number of requests that are currently proxied
+func (t *RequestTracker) Len() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	return len(t.requests)
+}
+
+// Requests returns the requests that are currently proxied, oldest first
+func (t *RequestTracker) Requests() []ActiveRequest {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	requests := make([]ActiveRequest, 0, len(t.requests))
+	for _, request := range t.requests {
+		requests = append(requests, request)
+	}
+	sort.Slice(requests, func(i, j int) bool { return requests[i].StartedAt.Before(requests[j].StartedAt) })
+	return requests
+}
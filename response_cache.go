@@ -0,0 +1,362 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// CachedResponse is a response of ollama stored in the response cache
+type CachedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header"`
+	Body       []byte      `json:"body"`
+	ExpiresAt  time.Time   `json:"expires_at"`
+}
+
+// Response returns a new http response for the given request using the cached data
+func (c *CachedResponse) Response(request *http.Request) *http.Response {
+	header := c.Header.Clone()
+	header.Set("Content-Length", strconv.Itoa(len(c.Body)))
+	return &http.Response{
+		Status:        http.StatusText(c.StatusCode),
+		StatusCode:    c.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(c.Body)),
+		ContentLength: int64(len(c.Body)),
+		Request:       request,
+	}
+}
+
+// CacheBackend stores cached responses by key
+type CacheBackend interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse)
+}
+
+// memoryCacheEntry is an entry of the LRU list of the memory cache
+type memoryCacheEntry struct {
+	key      string
+	response *CachedResponse
+}
+
+// MemoryCache is an in-memory LRU cache backend
+type MemoryCache struct {
+	mutex    sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	lru      *list.List
+}
+
+// NewMemoryCache will create a new in-memory cache holding up to capacity responses
+func NewMemoryCache(capacity int) *MemoryCache {
+	return &MemoryCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		lru:      list.New(),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	element, found := c.entries[key]
+	if !found {
+		return nil, false
+	}
+	entry := element.Value.(*memoryCacheEntry)
+	if time.Now().After(entry.response.ExpiresAt) {
+		c.lru.Remove(element)
+		delete(c.entries, key)
+		return nil, false
+	}
+	c.lru.MoveToFront(element)
+	return entry.response, true
+}
+
+func (c *MemoryCache) Set(key string, response *CachedResponse) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if element, found := c.entries[key]; found {
+		element.Value.(*memoryCacheEntry).response = response
+		c.lru.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&memoryCacheEntry{key: key, response: response})
+	for c.lru.Len() > c.capacity {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryCacheEntry).key)
+	}
+}
+
+// DiskCache is a cache backend storing every response as JSON file in a directory.
+// The modification time of a file is set to the expiry of its response, so that sweeping needn't read the files.
+type DiskCache struct {
+	dir     string
+	maxSize int64
+}
+
+// NewDiskCache will create a new on-disk cache in the given directory, holding up to maxSize bytes when swept
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir, maxSize: maxSize}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
+
+func (c *DiskCache) Get(key string) (*CachedResponse, bool) {
+	data, err := os.ReadFile(c.path(key))
+	if err != nil {
+		return nil, false
+	}
+	var response CachedResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		slog.Error("Failed to read cached response", "key", key, "error", err)
+		os.Remove(c.path(key))
+		return nil, false
+	}
+	if time.Now().After(response.ExpiresAt) {
+		os.Remove(c.path(key))
+		return nil, false
+	}
+	return &response, true
+}
+
+func (c *DiskCache) Set(key string, response *CachedResponse) {
+	data, err := json.Marshal(response)
+	if err != nil {
+		slog.Error("Failed to encode cached response", "key", key, "error", err)
+		return
+	}
+	tmpFile, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		slog.Error("Failed to write cached response", "key", key, "error", err)
+		return
+	}
+	_, err = tmpFile.Write(data)
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chtimes(tmpFile.Name(), time.Time{}, response.ExpiresAt)
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), c.path(key))
+	}
+	if err != nil {
+		slog.Error("Failed to write cached response", "key", key, "error", err)
+		os.Remove(tmpFile.Name())
+	}
+}
+
+// diskCacheFile is a file of the disk cache found by sweeping
+type diskCacheFile struct {
+	path      string
+	size      int64
+	expiresAt time.Time
+}
+
+// Sweep deletes the files of expired responses and, when the directory exceeds the max size,
+// the files of the responses expiring next.
+func (c *DiskCache) Sweep() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		slog.Error("Failed to sweep response cache directory", "error", err)
+		return
+	}
+	now := time.Now()
+	files := make([]diskCacheFile, 0, len(entries))
+	var totalSize int64
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(c.dir, entry.Name())
+		if now.After(info.ModTime()) {
+			os.Remove(path)
+			continue
+		}
+		files = append(files, diskCacheFile{path: path, size: info.Size(), expiresAt: info.ModTime()})
+		totalSize += info.Size()
+	}
+	if c.maxSize <= 0 || totalSize <= c.maxSize {
+		return
+	}
+	slices.SortFunc(files, func(a, b diskCacheFile) int { return a.expiresAt.Compare(b.expiresAt) })
+	for _, file := range files {
+		if totalSize <= c.maxSize {
+			break
+		}
+		if err := os.Remove(file.path); err == nil {
+			totalSize -= file.size
+		}
+	}
+}
+
+// Run sweeps the cache directory in the given interval until the context is done
+func (c *DiskCache) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Sweep()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ResponseCache caches responses of deterministic generations and embeddings
+type ResponseCache struct {
+	ttl          time.Duration
+	maxEntrySize int
+	backends     []CacheBackend
+}
+
+// NewResponseCache will create a new response cache using the given backends,
+// ordered from fastest to slowest.
+func NewResponseCache(ttl time.Duration, maxEntrySize int, backends ...CacheBackend) *ResponseCache {
+	return &ResponseCache{
+		ttl:          ttl,
+		maxEntrySize: maxEntrySize,
+		backends:     backends,
+	}
+}
+
+// Key returns the cache key of the given request, or an empty string
+// when the response of the request must not be cached.
+func (c *ResponseCache) Key(r *http.Request) string {
+	if r.Method != http.MethodPost {
+		return ""
+	}
+	route := r.URL.Path
+	switch route {
+	case "/api/embed", "/api/embeddings", "/api/generate", "/api/chat":
+	default:
+		return ""
+	}
+	data, err := readRequestBody(r)
+	if err != nil {
+		return ""
+	}
+	body := decodeJsonObject(data)
+	if body == nil {
+		return ""
+	}
+	model, _ := body["model"].(string)
+	if len(model) == 0 {
+		return ""
+	}
+	if (route == "/api/generate" || route == "/api/chat") && !isDeterministicGeneration(body) {
+		return ""
+	}
+	// keep_alive doesn't influence the response
+	delete(body, "keep_alive")
+	normalized, err := json.Marshal(body)
+	if err != nil {
+		return ""
+	}
+	hash := sha256.New()
+	hash.Write([]byte(route + "\n" + model + "\n"))
+	hash.Write(normalized)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// isDeterministicGeneration checks if the body of a generate/chat request
+// uses temperature 0 and a fixed seed, i.e. it always yields the same response.
+func isDeterministicGeneration(body map[string]any) bool {
+	options, _ := body["options"].(map[string]any)
+	temperature, hasTemperature := toFloat(options["temperature"])
+	_, hasSeed := toFloat(options["seed"])
+	return hasTemperature && temperature == 0 && hasSeed
+}
+
+// Get returns the cached response of the given key
+func (c *ResponseCache) Get(key string) (*CachedResponse, bool) {
+	for i, backend := range c.backends {
+		if response, found := backend.Get(key); found {
+			// promote the response to the faster backends
+			for _, faster := range c.backends[:i] {
+				faster.Set(key, response)
+			}
+			return response, true
+		}
+	}
+	return nil, false
+}
+
+// Set stores the given response by key
+func (c *ResponseCache) Set(key string, response *CachedResponse) {
+	for _, backend := range c.backends {
+		backend.Set(key, response)
+	}
+}
+
+// Record returns a body that passes through the body of the given response
+// and stores the response in the cache once the body has been read completely.
+func (c *ResponseCache) Record(key string, response *http.Response) io.ReadCloser {
+	return &cacheRecorder{
+		ReadCloser: response.Body,
+		cache:      c,
+		key:        key,
+		statusCode: response.StatusCode,
+		header:     response.Header.Clone(),
+	}
+}
+
+// cacheRecorder captures a response body to store it in the cache
+type cacheRecorder struct {
+	io.ReadCloser
+	cache      *ResponseCache
+	key        string
+	statusCode int
+	header     http.Header
+	buffer     bytes.Buffer
+	overflow   bool
+}
+
+func (r *cacheRecorder) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if !r.overflow {
+		r.buffer.Write(p[:n])
+		r.overflow = r.buffer.Len() > r.cache.maxEntrySize
+	}
+	if err == io.EOF && !r.overflow {
+		r.header.Del("Date")
+		r.header.Del("Content-Length")
+		r.header.Del("X-Cache")
+		r.cache.Set(r.key, &CachedResponse{
+			StatusCode: r.statusCode,
+			Header:     r.header,
+			Body:       bytes.Clone(r.buffer.Bytes()),
+			ExpiresAt:  time.Now().Add(r.cache.ttl),
+		})
+		r.overflow = true
+	}
+	return n, err
+}
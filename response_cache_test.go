@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResponseCacheKey(t *testing.T) {
+	cache := NewResponseCache(time.Minute, 1024, NewMemoryCache(10))
+	tests := []struct {
+		name   string
+		method string
+		route  string
+		body   string
+		cached bool
+	}{
+		{"deterministic generation", http.MethodPost, "/api/generate", `{"model":"llama3","prompt":"hi","options":{"temperature":0,"seed":1}}`, true},
+		{"generation without seed", http.MethodPost, "/api/generate", `{"model":"llama3","prompt":"hi","options":{"temperature":0}}`, false},
+		{"generation with temperature", http.MethodPost, "/api/chat", `{"model":"llama3","options":{"temperature":0.7,"seed":1}}`, false},
+		{"embedding", http.MethodPost, "/api/embed", `{"model":"nomic","input":"hi"}`, true},
+		{"embedding without model", http.MethodPost, "/api/embed", `{"input":"hi"}`, false},
+		{"other route", http.MethodPost, "/api/show", `{"model":"llama3"}`, false},
+		{"get request", http.MethodGet, "/api/tags", ``, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.route, strings.NewReader(tt.body))
+			if key := cache.Key(r); (len(key) > 0) != tt.cached {
+				t.Errorf("key = %q, want cached %t", key, tt.cached)
+			}
+		})
+	}
+}
+
+func TestResponseCacheKeyIgnoresKeepAlive(t *testing.T) {
+	cache := NewResponseCache(time.Minute, 1024, NewMemoryCache(10))
+	first := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"nomic","input":"hi","keep_alive":"5m"}`))
+	second := httptest.NewRequest(http.MethodPost, "/api/embed", strings.NewReader(`{"model":"nomic","input":"hi"}`))
+
+	if cache.Key(first) != cache.Key(second) {
+		t.Error("keep_alive changed the cache key")
+	}
+}
+
+func TestResponseCacheRecord(t *testing.T) {
+	cache := NewResponseCache(time.Minute, 1024, NewMemoryCache(10))
+	response := &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     http.Header{"Content-Type": {"application/json"}, "Content-Length": {"13"}},
+		Body:       io.NopCloser(strings.NewReader(`{"embed":[1]}`)),
+	}
+
+	body := cache.Record("key", response)
+	if data, _ := io.ReadAll(body); string(data) != `{"embed":[1]}` {
+		t.Fatalf("body = %s, want passed through body", data)
+	}
+
+	cached, found := cache.Get("key")
+	if !found {
+		t.Fatal("response not cached after reading the body")
+	}
+	if string(cached.Body) != `{"embed":[1]}` || cached.Header.Get("Content-Length") != "" {
+		t.Errorf("cached = %+v, want body without content length header", cached)
+	}
+}
+
+func TestResponseCacheRecordSkipsLargeBodies(t *testing.T) {
+	cache := NewResponseCache(time.Minute, 4, NewMemoryCache(10))
+	response := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: io.NopCloser(strings.NewReader("too large"))}
+
+	io.ReadAll(cache.Record("key", response))
+
+	if _, found := cache.Get("key"); found {
+		t.Error("response larger than max entry size got cached")
+	}
+}
+
+func TestMemoryCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := NewMemoryCache(2)
+	expiresAt := time.Now().Add(time.Minute)
+	cache.Set("a", &CachedResponse{ExpiresAt: expiresAt})
+	cache.Set("b", &CachedResponse{ExpiresAt: expiresAt})
+	cache.Get("a")
+	cache.Set("c", &CachedResponse{ExpiresAt: expiresAt})
+
+	if _, found := cache.Get("b"); found {
+		t.Error("least recently used entry not evicted")
+	}
+	if _, found := cache.Get("a"); !found {
+		t.Error("recently used entry evicted")
+	}
+}
+
+func TestMemoryCacheExpires(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", &CachedResponse{ExpiresAt: time.Now().Add(-time.Second)})
+
+	if _, found := cache.Get("a"); found {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestResponseCachePromotesFromSlowerBackend(t *testing.T) {
+	memory := NewMemoryCache(10)
+	disk, err := NewDiskCache(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("key", &CachedResponse{StatusCode: http.StatusOK, Body: []byte("cached"), ExpiresAt: time.Now().Add(time.Minute)})
+	cache := NewResponseCache(time.Minute, 1024, memory, disk)
+
+	if response, found := cache.Get("key"); !found || string(response.Body) != "cached" {
+		t.Fatalf("response = %+v, want response of disk cache", response)
+	}
+	if _, found := memory.Get("key"); !found {
+		t.Error("response not promoted to memory cache")
+	}
+}
+
+func TestDiskCacheSweep(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDiskCache(dir, 150)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	body := bytes.Repeat([]byte("a"), 10)
+	disk.Set("expired", &CachedResponse{Body: body, ExpiresAt: now.Add(-time.Second)})
+	disk.Set("expiring-first", &CachedResponse{Body: body, ExpiresAt: now.Add(time.Minute)})
+	disk.Set("expiring-last", &CachedResponse{Body: body, ExpiresAt: now.Add(time.Hour)})
+
+	disk.Sweep()
+
+	entries, _ := os.ReadDir(dir)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if !slices.Equal(names, []string{"expiring-last.json"}) {
+		t.Errorf("files = %v, want expired file and file exceeding max size deleted", names)
+	}
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"time"
+
+	"github.com/ollama/ollama/api"
+)
+
+// RewritePolicy describes how ollama options of a request get clamped or forced
+type RewritePolicy struct {
+	MaxOptions   map[string]float64 `json:"max_options,omitempty"`
+	ForceOptions map[string]any     `json:"force_options,omitempty"`
+	MaxKeepAlive string             `json:"max_keep_alive,omitempty"`
+	KeepAlive    string             `json:"keep_alive,omitempty"`
+	Think        *bool              `json:"think,omitempty"`
+	SystemPrompt string             `json:"system_prompt,omitempty"`
+}
+
+// Validate checks the policy for malformed values
+func (p *RewritePolicy) Validate() error {
+	if len(p.MaxKeepAlive) > 0 {
+		if _, err := time.ParseDuration(p.MaxKeepAlive); err != nil {
+			return fmt.Errorf("invalid max_keep_alive %q: %w", p.MaxKeepAlive, err)
+		}
+	}
+	if len(p.KeepAlive) > 0 {
+		if _, err := time.ParseDuration(p.KeepAlive); err != nil {
+			return fmt.Errorf("invalid keep_alive %q: %w", p.KeepAlive, err)
+		}
+	}
+	return nil
+}
+
+// IsEmpty returns true when the policy doesn't change anything
+func (p *RewritePolicy) IsEmpty() bool {
+	return p == nil || (len(p.MaxOptions) == 0 && len(p.ForceOptions) == 0 &&
+		len(p.MaxKeepAlive) == 0 && len(p.KeepAlive) == 0 && p.Think == nil && len(p.SystemPrompt) == 0)
+}
+
+// Merge returns a new policy where settings of the given override take precedence
+func (p *RewritePolicy) Merge(override *RewritePolicy) *RewritePolicy {
+	merged := &RewritePolicy{
+		MaxOptions:   make(map[string]float64),
+		ForceOptions: make(map[string]any),
+	}
+	for _, policy := range []*RewritePolicy{p, override} {
+		if policy == nil {
+			continue
+		}
+		maps.Copy(merged.MaxOptions, policy.MaxOptions)
+		maps.Copy(merged.ForceOptions, policy.ForceOptions)
+		if len(policy.MaxKeepAlive) > 0 {
+			merged.MaxKeepAlive = policy.MaxKeepAlive
+		}
+		if len(policy.KeepAlive) > 0 {
+			merged.KeepAlive = policy.KeepAlive
+		}
+		if policy.Think != nil {
+			merged.Think = policy.Think
+		}
+		if len(policy.SystemPrompt) > 0 {
+			merged.SystemPrompt = policy.SystemPrompt
+		}
+	}
+	return merged
+}
+
+// Apply rewrites the given request body of an ollama route according to the policy
+// and returns a description of every applied change.
+func (p *RewritePolicy) Apply(route string, body map[string]any) []string {
+	if p.IsEmpty() {
+		return nil
+	}
+	isGenerative := route == "/api/chat" || route == "/api/generate"
+	isEmbedding := route == "/api/embed" || route == "/api/embeddings"
+	if !isGenerative && !isEmbedding {
+		return nil
+	}
+
+	changes := make([]string, 0)
+
+	options, _ := body["options"].(map[string]any)
+	if options == nil {
+		options = make(map[string]any)
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.ForceOptions)) {
+		value := p.ForceOptions[name]
+		if current, found := options[name]; !found || fmt.Sprint(current) != fmt.Sprint(value) {
+			options[name] = value
+			changes = append(changes, describeChange("options."+name, value, current))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(p.MaxOptions)) {
+		limit := p.MaxOptions[name]
+		if current, ok := toFloat(options[name]); ok && current > limit {
+			options[name] = limit
+			changes = append(changes, describeChange("options."+name, limit, current))
+		}
+	}
+	if len(options) > 0 {
+		body["options"] = options
+	}
+
+	current, hasKeepAlive := body["keep_alive"]
+	if len(p.KeepAlive) > 0 {
+		if !hasKeepAlive || fmt.Sprint(current) != p.KeepAlive {
+			body["keep_alive"] = p.KeepAlive
+			changes = append(changes, describeChange("keep_alive", p.KeepAlive, current))
+		}
+	} else if len(p.MaxKeepAlive) > 0 && hasKeepAlive {
+		limit, _ := time.ParseDuration(p.MaxKeepAlive)
+		if requested, err := parseKeepAlive(current); err != nil || requested > limit {
+			body["keep_alive"] = p.MaxKeepAlive
+			changes = append(changes, describeChange("keep_alive", p.MaxKeepAlive, current))
+		}
+	}
+
+	if !isGenerative {
+		return changes
+	}
+
+	if p.Think != nil {
+		if current, found := body["think"]; !found || current != *p.Think {
+			body["think"] = *p.Think
+			changes = append(changes, describeChange("think", *p.Think, current))
+		}
+	}
+
+	if len(p.SystemPrompt) > 0 {
+		if route == "/api/chat" {
+			messages, _ := body["messages"].([]any)
+			hasSystemMessage := false
+			for _, message := range messages {
+				if m, ok := message.(map[string]any); ok && m["role"] == "system" {
+					hasSystemMessage = true
+					break
+				}
+			}
+			if !hasSystemMessage {
+				systemMessage := map[string]any{"role": "system", "content": p.SystemPrompt}
+				body["messages"] = append([]any{systemMessage}, messages...)
+				changes = append(changes, "messages+=system")
+			}
+		} else if system, _ := body["system"].(string); len(system) == 0 {
+			body["system"] = p.SystemPrompt
+			changes = append(changes, "system=default")
+		}
+	}
+
+	return changes
+}
+
+// parseKeepAlive parses a keep_alive value of a request, a negative value means "forever"
+func parseKeepAlive(value any) (time.Duration, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return 0, err
+	}
+	var duration api.Duration
+	if err := json.Unmarshal(data, &duration); err != nil {
+		return 0, err
+	}
+	return duration.Duration, nil
+}
+
+// toFloat returns the given JSON number as float
+func toFloat(value any) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// describeChange returns a short description of a changed value
+func describeChange(name string, value any, previous any) string {
+	if previous == nil {
+		return fmt.Sprintf("%s=%v", name, value)
+	}
+	return fmt.Sprintf("%s=%v (was %v)", name, value, previous)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRewritePolicyClampsOptions(t *testing.T) {
+	policy := &RewritePolicy{MaxOptions: map[string]float64{"num_ctx": 4096, "temperature": 1}}
+	body := map[string]any{
+		"model":   "llama3",
+		"options": map[string]any{"num_ctx": float64(32768), "temperature": 0.5},
+	}
+
+	changes := policy.Apply("/api/generate", body)
+
+	options := body["options"].(map[string]any)
+	if options["num_ctx"] != float64(4096) {
+		t.Errorf("num_ctx = %v, want 4096", options["num_ctx"])
+	}
+	if options["temperature"] != 0.5 {
+		t.Errorf("temperature = %v, want unchanged 0.5", options["temperature"])
+	}
+	if want := []string{"options.num_ctx=4096 (was 32768)"}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+}
+
+func TestRewritePolicyForcesOptions(t *testing.T) {
+	policy := &RewritePolicy{ForceOptions: map[string]any{"seed": float64(42)}}
+	body := map[string]any{"model": "llama3"}
+
+	changes := policy.Apply("/api/chat", body)
+
+	options, _ := body["options"].(map[string]any)
+	if options["seed"] != float64(42) {
+		t.Errorf("seed = %v, want 42", options["seed"])
+	}
+	if len(changes) != 1 {
+		t.Errorf("changes = %v, want one change", changes)
+	}
+	if changes := policy.Apply("/api/chat", body); len(changes) != 0 {
+		t.Errorf("changes of already rewritten body = %v, want none", changes)
+	}
+}
+
+func TestRewritePolicyClampsKeepAlive(t *testing.T) {
+	policy := &RewritePolicy{MaxKeepAlive: "10m"}
+	tests := []struct {
+		name      string
+		keepAlive any
+		want      any
+	}{
+		{"shorter duration", "5m", "5m"},
+		{"longer duration", "1h", "10m"},
+		{"forever", float64(-1), "10m"},
+		{"seconds", float64(60), float64(60)},
+		{"malformed", "sometimes", "10m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]any{"model": "llama3", "keep_alive": tt.keepAlive}
+			policy.Apply("/api/generate", body)
+			if body["keep_alive"] != tt.want {
+				t.Errorf("keep_alive = %v, want %v", body["keep_alive"], tt.want)
+			}
+		})
+	}
+}
+
+func TestRewritePolicyAddsSystemPrompt(t *testing.T) {
+	policy := &RewritePolicy{SystemPrompt: "Be nice"}
+
+	chat := map[string]any{"messages": []any{map[string]any{"role": "user", "content": "hi"}}}
+	policy.Apply("/api/chat", chat)
+	messages := chat["messages"].([]any)
+	if len(messages) != 2 || messages[0].(map[string]any)["role"] != "system" {
+		t.Errorf("messages = %v, want leading system message", messages)
+	}
+
+	withSystem := map[string]any{"messages": []any{map[string]any{"role": "system", "content": "Be brief"}}}
+	policy.Apply("/api/chat", withSystem)
+	if messages := withSystem["messages"].([]any); len(messages) != 1 {
+		t.Errorf("messages = %v, want existing system message kept", messages)
+	}
+
+	generate := map[string]any{"prompt": "hi"}
+	policy.Apply("/api/generate", generate)
+	if generate["system"] != "Be nice" {
+		t.Errorf("system = %v, want default system prompt", generate["system"])
+	}
+}
+
+func TestRewritePolicyIgnoresOtherRoutes(t *testing.T) {
+	policy := &RewritePolicy{ForceOptions: map[string]any{"seed": float64(42)}, SystemPrompt: "Be nice"}
+	body := map[string]any{"model": "llama3"}
+
+	if changes := policy.Apply("/api/show", body); changes != nil {
+		t.Errorf("changes = %v, want none", changes)
+	}
+	embed := map[string]any{"model": "nomic", "input": "hi"}
+	policy.Apply("/api/embed", embed)
+	if _, found := embed["system"]; found {
+		t.Error("system prompt added to embedding request")
+	}
+}
+
+func TestRewritePolicyMerge(t *testing.T) {
+	think := false
+	global := &RewritePolicy{MaxOptions: map[string]float64{"num_ctx": 8192, "num_predict": 512}, KeepAlive: "5m"}
+	override := &RewritePolicy{MaxOptions: map[string]float64{"num_ctx": 2048}, Think: &think}
+
+	merged := global.Merge(override)
+
+	if want := map[string]float64{"num_ctx": 2048, "num_predict": 512}; !reflect.DeepEqual(merged.MaxOptions, want) {
+		t.Errorf("max options = %v, want %v", merged.MaxOptions, want)
+	}
+	if merged.KeepAlive != "5m" || merged.Think == nil || *merged.Think {
+		t.Errorf("merged = %+v, want keep alive of global and think of override", merged)
+	}
+}
+
+func TestRewritePolicyValidate(t *testing.T) {
+	if err := (&RewritePolicy{MaxKeepAlive: "10m", KeepAlive: "1m"}).Validate(); err != nil {
+		t.Errorf("valid policy: %v", err)
+	}
+	if err := (&RewritePolicy{MaxKeepAlive: "ten minutes"}).Validate(); err == nil {
+		t.Error("invalid max_keep_alive accepted")
+	}
+}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
 	"log/slog"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 )
 
 type Server struct {
@@ -33,9 +36,44 @@ func NewServer(ctx context.Context, host string, port int, handlerFuncs map[stri
 	}
 }
 
+// NewRedirectServer will create a new server that redirects all requests to HTTPS at given port
+func NewRedirectServer(ctx context.Context, host string, port int, httpsPort int) *Server {
+	redirect := func(w http.ResponseWriter, r *http.Request) {
+		targetHost := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			targetHost = h
+		}
+		if httpsPort != 443 {
+			targetHost = net.JoinHostPort(targetHost, strconv.Itoa(httpsPort))
+		}
+		target := url.URL{Scheme: "https", Host: targetHost, Path: r.URL.Path, RawQuery: r.URL.RawQuery}
+		http.Redirect(w, r, target.String(), http.StatusPermanentRedirect)
+	}
+	return NewServer(ctx, host, port, map[string]func(http.ResponseWriter, *http.Request){"/": redirect})
+}
+
+// SetTLSConfig will let the server serve HTTPS using the given config
+func (s *Server) SetTLSConfig(tlsConfig *tls.Config) {
+	s.TLSConfig = tlsConfig
+}
+
+// URL returns the base URL the server is listening at
+func (s *Server) URL() string {
+	if s.TLSConfig != nil {
+		return fmt.Sprintf("https://%s", s.Addr)
+	}
+	return fmt.Sprintf("http://%s", s.Addr)
+}
+
 func (s *Server) Run() {
-	slog.Info(fmt.Sprintf("Server listening at %s", s.Addr))
-	serverErr := s.ListenAndServe()
+	slog.Info(fmt.Sprintf("Server listening at %s", s.URL()))
+	var serverErr error
+	if s.TLSConfig != nil {
+		// certificate is provided by the TLS config
+		serverErr = s.ListenAndServeTLS("", "")
+	} else {
+		serverErr = s.ListenAndServe()
+	}
 	if !errors.Is(serverErr, http.ErrServerClosed) {
 		slog.Error("Failed to start server", "error", serverErr)
 	} else {
@@ -4,12 +4,14 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"net/url"
-	"slices"
-	"strings"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -25,25 +27,68 @@ const (
 )
 
 type ServerHandler struct {
-	apiKeys []string
-
-	preloadModels      []string
-	preloadModelStatus PreloadModelStatus
+	keyStore      *KeyStore
+	jwtValidator  *JwtValidator
+	introspector  *TokenIntrospector
+	credentials   CredentialSources
+	ipRules       *IPRules
+	ipResolver    *ClientIPResolver
+	bruteForce    *BruteForceGuard
+	adminApiKey   string
+	keyExpiryWarn time.Duration
+	rewritePolicy *RewritePolicy
+	modelAliases  ModelAliases
+	responseCache *ResponseCache
+	coalescer     *RequestCoalescer
+	auditLog      *AuditLog
+	moderation    *Moderation
+	piiRedaction  bool
+	piiRestore    bool
+
+	preloadModels        []string
+	preloads             *PreloadTracker
+	preloadFailurePolicy PreloadFailurePolicy
+	preloadWarmUp        PreloadWarmUp
+	preloadPulls         PreloadPulls
+	preloadRefresh       PreloadRefresh
+	readiness            ReadinessCriteria
+	healthProbesAuth     bool
+
+	ctx       context.Context
+	startedAt time.Time
+	requests  *RequestTracker
+	keyUsage  *KeyUsageTracker
+	pulls     *PullTracker
+	events    *EventBus
+
+	eventBodies bool
 
 	upstreamBaseURL               *url.URL
-	lastSuccessfulPingTime        time.Time
+	health                        *HealthChecker
 	userModelMetricsWebhookUrl    string
 	userModelMetricsWebhookApiKey string
 }
 
 // NewServerHandler will create a new server
-func NewServerHandler(apiKeys []string, preloadModels []string) *ServerHandler {
+func NewServerHandler(keyStore *KeyStore, preloadModels []string) *ServerHandler {
 	return &ServerHandler{
-		apiKeys:       apiKeys,
+		keyStore:      keyStore,
 		preloadModels: preloadModels,
+		preloads:      NewPreloadTracker(preloadModels),
+		ctx:           context.Background(),
+		startedAt:     time.Now(),
+		requests:      NewRequestTracker(),
+		keyUsage:      NewKeyUsageTracker(),
+		pulls:         NewPullTracker(),
+		events:        NewEventBus(),
 	}
 }
 
+// SetContext will set the context of the server lifetime, background work started by requests is bound to it
+func (s *ServerHandler) SetContext(ctx context.Context) {
+	s.ctx = ctx
+}
+
 // SetUpstreamURL will set base url of upstream on server
 func (s *ServerHandler) SetUpstreamURL(baseURL *url.URL) {
 	s.upstreamBaseURL = baseURL
@@ -55,6 +100,151 @@ func (s *ServerHandler) GetUpstreamURL() *url.URL {
 	return s.upstreamBaseURL
 }
 
+// SetHealthChecker will set the checker providing the health of the upstream
+func (s *ServerHandler) SetHealthChecker(checker *HealthChecker) {
+	s.health = checker
+}
+
+// SetPreloadFailurePolicy will set the policy deciding whether failed preloads make the proxy unready
+func (s *ServerHandler) SetPreloadFailurePolicy(policy PreloadFailurePolicy) {
+	s.preloadFailurePolicy = policy
+	slog.Info(fmt.Sprintf("Using preload failure policy %s", policy))
+}
+
+// PreloadPulls configures pulling preloaded models
+type PreloadPulls struct {
+	// Concurrency is the number of models pulled at the same time
+	Concurrency int
+	// Retries is the number of retries of a failed pull, the delay doubles with each retry
+	Retries      int
+	RetryBackoff time.Duration
+}
+
+// maxPreloadRetryDelay caps the doubled delay between retries of a failed pull
+const maxPreloadRetryDelay = 10 * time.Minute
+
+// RetryDelay returns the delay before the given retry of a failed pull
+func (p PreloadPulls) RetryDelay(attempt int) time.Duration {
+	delay := p.RetryBackoff
+	for i := 1; i < attempt && delay < maxPreloadRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxPreloadRetryDelay)
+}
+
+// errPullInProgress is returned when a model is already being pulled, e.g. via the admin API
+var errPullInProgress = errors.New("model is already being pulled")
+
+// SetPreloadPulls will set how preloaded models get pulled
+func (s *ServerHandler) SetPreloadPulls(pulls PreloadPulls) {
+	s.preloadPulls = pulls
+}
+
+// SetJwtValidator will set the validator used to accept JWT bearer tokens
+func (s *ServerHandler) SetJwtValidator(validator *JwtValidator) {
+	s.jwtValidator = validator
+	slog.Info(fmt.Sprintf("Accepting JWT bearer tokens signed by keys of %s", validator.jwksSource))
+}
+
+// SetTokenIntrospector will set the introspector used to accept opaque bearer tokens
+func (s *ServerHandler) SetTokenIntrospector(introspector *TokenIntrospector) {
+	s.introspector = introspector
+	slog.Info(fmt.Sprintf("Accepting opaque bearer tokens introspected at %s", introspector.url))
+}
+
+// SetCredentialSources will set the sources of an API key accepted besides the "Authorization: Bearer" header
+func (s *ServerHandler) SetCredentialSources(sources CredentialSources) {
+	s.credentials = sources
+	slog.Info(fmt.Sprintf("Accepting credentials via header %s: %t, basic auth: %t, query parameter %s: %t",
+		apiKeyHeader, sources.ApiKeyHeader, sources.BasicAuth, apiKeyQueryParam, sources.QueryParam))
+}
+
+// SetIPRules will set the global client IP rules and the resolver used to derive the client IP of a request
+func (s *ServerHandler) SetIPRules(rules *IPRules, resolver *ClientIPResolver) {
+	s.ipRules = rules
+	s.ipResolver = resolver
+	if !rules.IsEmpty() {
+		slog.Info(fmt.Sprintf("Using %d allowed and %d denied client CIDRs", len(rules.allow), len(rules.deny)))
+	}
+	if len(resolver.trustedProxies) > 0 {
+		slog.Info(fmt.Sprintf("Trusting X-Forwarded-For of %d proxy CIDRs", len(resolver.trustedProxies)))
+	}
+}
+
+// SetBruteForceGuard will set the guard blocking clients with repeated failed authentication attempts
+func (s *ServerHandler) SetBruteForceGuard(guard *BruteForceGuard) {
+	s.bruteForce = guard
+	slog.Info(fmt.Sprintf("Banning clients for %s after %d failed authentication attempts", guard.banDuration, guard.maxFailures))
+}
+
+// SetAdminApiKey will set the API key required to use the admin API, the admin API is disabled without key
+func (s *ServerHandler) SetAdminApiKey(apiKey string) {
+	s.adminApiKey = apiKey
+	if len(apiKey) > 0 {
+		slog.Info("Using admin API")
+	}
+}
+
+// SetKeyExpiryWarning will set the duration before expiry of a key to start warning about it
+func (s *ServerHandler) SetKeyExpiryWarning(warnBefore time.Duration) {
+	s.keyExpiryWarn = warnBefore
+}
+
+// SetEventBodies will set whether subscribers of the event stream may ask for request bodies
+func (s *ServerHandler) SetEventBodies(enabled bool) {
+	s.eventBodies = enabled
+	if enabled {
+		slog.Info("Request bodies may be included in the event stream")
+	}
+}
+
+// SetRewritePolicy will set the global policy to rewrite ollama options of requests
+func (s *ServerHandler) SetRewritePolicy(policy *RewritePolicy) {
+	s.rewritePolicy = policy
+	if !policy.IsEmpty() {
+		slog.Info("Using global request rewrite policy")
+	}
+}
+
+// SetModelAliases will set the aliases used to map virtual to concrete model names
+func (s *ServerHandler) SetModelAliases(aliases ModelAliases) {
+	s.modelAliases = aliases
+	for alias, model := range aliases {
+		slog.Info(fmt.Sprintf("Using model alias %s for %s", alias, model))
+	}
+}
+
+// SetResponseCache will set the cache used for responses of deterministic requests
+func (s *ServerHandler) SetResponseCache(cache *ResponseCache) {
+	s.responseCache = cache
+}
+
+// SetRequestCoalescer will set the coalescer used to share identical in-flight requests
+func (s *ServerHandler) SetRequestCoalescer(coalescer *RequestCoalescer) {
+	s.coalescer = coalescer
+}
+
+// SetAuditLog will set the audit log receiving prompts and completions
+func (s *ServerHandler) SetAuditLog(auditLog *AuditLog) {
+	s.auditLog = auditLog
+}
+
+// SetModeration will set the moderation of prompts and outputs
+func (s *ServerHandler) SetModeration(moderation *Moderation) {
+	s.moderation = moderation
+	slog.Info(fmt.Sprintf("Using content moderation with action %s", moderation.Action))
+}
+
+// SetPiiRedaction will set whether PII in prompts gets replaced by placeholders
+// and whether the placeholders get restored in the response.
+func (s *ServerHandler) SetPiiRedaction(enabled bool, restore bool) {
+	s.piiRedaction = enabled
+	s.piiRestore = restore
+	if enabled {
+		slog.Info(fmt.Sprintf("Using PII redaction, restore %t", restore))
+	}
+}
+
 // SetUpstreamURL will set base url of upstream on server
 func (s *ServerHandler) SetUserModelMetricsWebhook(url string, apiKey string) {
 	s.userModelMetricsWebhookUrl = url
@@ -71,48 +261,143 @@ func (s *ServerHandler) SetUserModelMetricsWebhook(url string, apiKey string) {
 func (s *ServerHandler) ServeHttpProxy(w http.ResponseWriter, r *http.Request) {
 	backendURL := s.GetUpstreamURL()
 	requestId := uuid.New().String()
+	credential := s.credentials.Take(r)
+	clientIP := s.ipResolver.ClientIP(r)
 	logger := slog.With(
 		"requestId", requestId,
 		"client", r.RemoteAddr,
+		"clientIp", clientIP,
 		"backendURL", backendURL,
 		"method", r.Method,
 		"url", r.URL,
 		"proto", r.Proto)
 	logger.Info("Handle request")
-	if s.authRequestHandle(w, r) {
-		upstreamHandler := NewProxyHandler(backendURL, s.forwardUserModelMetrics, logger)
-		upstreamHandler.ProxyRequest(w, r)
+	event := ProxyEvent{
+		RequestId: requestId,
+		Method:    r.Method,
+		Route:     r.URL.Path,
+		ClientIP:  clientIP.String(),
 	}
+	s.events.Publish(event.as(EventRequestStart, ""))
+	identity, ok := s.authClientRequest(w, r, clientIP, credential)
+	if !ok {
+		s.events.Publish(event.as(EventAuth, "rejected"))
+		return
+	}
+	event.Model = requestModel(r)
+	if identity != nil {
+		event.KeyName = identity.Name
+		event.UserName = identity.UserName
+		logger = logger.With("identity", identity.Name, "auth", identity.AuthMethod)
+		if len(identity.UserName) > 0 {
+			logger = logger.With("user", identity.UserName)
+		}
+		if !s.authorizeRequest(w, r, identity, logger) {
+			s.events.Publish(event.as(EventAuth, "forbidden"))
+			return
+		}
+	}
+	if s.eventBodies && s.events.WantsBodies() {
+		if data, err := readRequestBody(r); err == nil {
+			event.Body = string(data[:min(len(data), eventBodyMaxSize)])
+		}
+	}
+	s.events.Publish(event.as(EventAuth, "ok"))
+	event.Body = ""
+	startedAt := time.Now()
+	defer func() {
+		if r.Context().Err() != nil {
+			event = event.as(EventCancel, r.Context().Err().Error())
+		} else {
+			event = event.as(EventDone, event.Result)
+		}
+		event.DurationMs = time.Since(startedAt).Milliseconds()
+		s.events.Publish(event)
+	}()
+
+	var moderationFlag string
+	if s.moderation != nil {
+		var rejection *moderationRejection
+		if moderationFlag, rejection = s.moderateRequest(r, logger); rejection != nil {
+			event.Result = "moderated"
+			w.WriteHeader(rejection.status)
+			fmt.Fprintln(w, rejection.message)
+			if s.auditLog != nil {
+				s.writeAuditRecord(r, requestId, identity, rejection.status, rejection.message)
+			}
+			return
+		}
+	}
+	activeRequest := ActiveRequest{
+		RequestId: requestId,
+		Method:    r.Method,
+		Route:     r.URL.Path,
+		Model:     event.Model,
+		ClientIP:  event.ClientIP,
+		KeyName:   event.KeyName,
+		UserName:  event.UserName,
+		StartedAt: startedAt,
+	}
+	defer s.requests.Start(activeRequest)()
+
+	if identity != nil && identity.Key != nil {
+		if expiresIn, expires := identity.Key.ExpiresIn(time.Now()); expires {
+			w.Header().Set("X-API-Key-Expires-In", strconv.Itoa(int(expiresIn.Seconds())))
+		}
+	}
+	upstreamHandler := NewProxyHandler(backendURL, s.forwardUserModelMetrics, logger)
+	upstreamHandler.SetRequestContext(requestId, identity)
+	upstreamHandler.SetRewritePolicy(s.rewritePolicyFor(identity))
+	upstreamHandler.SetModelAliases(s.modelAliases)
+	if identity == nil || identity.Key == nil || !identity.Key.NoCache {
+		upstreamHandler.SetResponseCache(s.responseCache)
+	}
+	if s.coalescer != nil {
+		upstreamHandler.SetRequestCoalescer(s.coalescer)
+	}
+	if s.auditLog != nil {
+		upstreamHandler.SetAuditLog(s.auditLog)
+	}
+	if s.moderation != nil {
+		upstreamHandler.SetModeration(s.moderation, moderationFlag)
+	}
+	upstreamHandler.SetPiiRedaction(s.piiRedactionFor(identity), s.piiRestore)
+	upstreamHandler.SetEventBus(s.events, event)
+	upstreamHandler.ProxyRequest(w, r)
 }
 
 // ServeHttpPing will be called by the http server to handle a "ping" request, checking if upstream is running ok
 func (s *ServerHandler) ServeHttpPing(w http.ResponseWriter, r *http.Request) {
 	backendURL := s.GetUpstreamURL()
 	requestId := uuid.New().String()
+	credential := s.credentials.Take(r)
+	clientIP := s.ipResolver.ClientIP(r)
 	logger := slog.With(
 		"requestId", requestId,
 		"client", r.RemoteAddr,
+		"clientIp", clientIP,
 		"backendURL", backendURL,
 		"method", r.Method,
 		"url", r.URL,
 		"proto", r.Proto)
-	if s.authRequestHandle(w, r) {
+	if _, ok := s.authClientRequest(w, r, clientIP, credential); ok {
 		if s.isUpstreamRunning() {
-			switch s.preloadModelStatus {
+			switch s.preloads.Status() {
 			case Unknown:
+				// a response with status 204 can't have a body
 				logger.Warn("Upstream available, preloading unknown")
 				w.WriteHeader(http.StatusNoContent)
-				w.Write([]byte("{\"status\": \"Model preload not started yet\"}"))
 			case InProgress:
 				logger.Info("Upstream is available, preloading in progress")
 				w.WriteHeader(http.StatusNoContent)
-				w.Write([]byte("{\"status\": \"Model preload in progress\"}"))
 			case Preloaded:
-				logFunc := logger.Debug
-				if time.Since(s.lastSuccessfulPingTime) > 60*time.Second {
-					logFunc = logger.Info
+				if failed, total := s.preloads.Failed(); s.preloadFailurePolicy.Unready(failed, total) {
+					logger.Warn("Upstream is available, preloading failed", "failed", failed, "total", total)
+					w.WriteHeader(http.StatusServiceUnavailable)
+					w.Write([]byte("{\"status\": \"Model preload failed\"}"))
+					return
 				}
-				logFunc("Upstream is available, preloading done")
+				logger.Debug("Upstream is available, preloading done")
 				w.WriteHeader(http.StatusOK)
 				w.Write([]byte("{\"status\": \"Models preloaded\"}"))
 			}
@@ -126,60 +411,296 @@ func (s *ServerHandler) ServeHttpPing(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-// authRequestHandler checks request for authorization details and
-// returns true when request is authorized.
-func (s *ServerHandler) authRequestHandle(w http.ResponseWriter, r *http.Request) bool {
-	if s.requireApiKeyAuthorization() {
-		authHeader := r.Header.Get("Authorization")
+// authRequestHandler checks the credential of the request and
+// returns true when request is authorized, together with the identity of the caller.
+// The identity is nil when no authorization is required.
+func (s *ServerHandler) authRequestHandle(w http.ResponseWriter, r *http.Request, credential *requestCredential) (*Identity, bool) {
+	return s.authClientRequest(w, r, s.ipResolver.ClientIP(r), credential)
+}
 
-		if authHeader == "" {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintln(w, "Unauthorized: Missing Authorization header")
-			slog.Info("Unauthorized: Missing Authorization header")
-			return false
-		}
+// authClientRequest checks the credential of the request like authRequestHandle, using the already resolved client IP
+func (s *ServerHandler) authClientRequest(w http.ResponseWriter, r *http.Request, clientIP netip.Addr, credential *requestCredential) (*Identity, bool) {
+	if allowed, reason := s.ipRules.CheckDeny(clientIP); !allowed {
+		s.rejectForbiddenIP(w, clientIP, "", reason)
+		return nil, false
+	}
+	// with keys overriding the global allowlist, the global allowlist gets checked once the key is known
+	if !s.keyStore.OverridesGlobalIPRules() && !s.checkGlobalIPRules(w, clientIP, nil) {
+		return nil, false
+	}
+	if !s.requireApiKeyAuthorization() {
+		return nil, true
+	}
+	if s.rejectBlockedClient(w, clientIP) {
+		return nil, false
+	}
 
-		parts := strings.SplitN(authHeader, " ", 2)
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintln(w, "Unauthorized: Invalid Authorization header format")
-			slog.Info("Unauthorized: Invalid Authorization header format")
-			return false
+	certKey := s.keyStore.LookupClientCert(r.TLS)
+	if credential == nil {
+		if !s.checkGlobalIPRules(w, clientIP, certKey) {
+			return nil, false
 		}
-
-		apiKey := parts[1]
-
-		if !s.isValidAPIKey(apiKey) {
-			w.WriteHeader(http.StatusUnauthorized)
-			fmt.Fprintln(w, "Unauthorized: Invalid API key")
-			slog.Info("Unauthorized: Invalid API key")
-			return false
+		if certKey == nil {
+			s.rejectUnauthorized(w, "Missing Authorization header")
+			return nil, false
+		}
+		if err := certKey.CheckValidity(time.Now()); err != nil {
+			s.rejectUnauthorized(w, err.Error())
+			return nil, false
+		}
+		if certKey.RequireClientCertAndKey {
+			s.rejectUnauthorized(w, "API key required in addition to client certificate")
+			return nil, false
+		}
+		if allowed, reason := certKey.CheckClientIP(clientIP); !allowed {
+			s.rejectForbiddenIP(w, clientIP, certKey.Name, reason)
+			return nil, false
+		}
+		s.keyStore.MarkUsed(certKey.Name)
+		return NewKeyIdentity(certKey, AuthMethodClientCert), true
+	}
+	apiKey := s.keyStore.Lookup(credential.Value)
+	if !s.checkGlobalIPRules(w, clientIP, apiKey) {
+		return nil, false
+	}
+	if len(credential.Malformed) > 0 {
+		s.recordAuthFailure(clientIP)
+		s.rejectUnauthorized(w, credential.Malformed)
+		return nil, false
+	}
+	if apiKey == nil && s.jwtValidator != nil && looksLikeJwt(credential.Value) {
+		claims, err := s.jwtValidator.Validate(credential.Value)
+		if err != nil {
+			s.recordAuthFailure(clientIP)
+			s.rejectUnauthorized(w, fmt.Sprintf("Invalid token: %s", err))
+			return nil, false
+		}
+		s.recordAuthSuccess(clientIP)
+		identity, err := s.jwtValidator.Identity(claims)
+		if err != nil {
+			s.rejectForbidden(w, err.Error())
+			return nil, false
+		}
+		return identity, true
+	}
+	if apiKey == nil && s.introspector != nil {
+		result, err := s.introspector.Introspect(r.Context(), credential.Value)
+		if errors.Is(err, ErrIntrospectionRateLimited) {
+			slog.Warn("Too Many Requests: Token introspection rate limit exceeded", "clientIp", clientIP)
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			fmt.Fprintln(w, "Too Many Requests: Token introspection rate limit exceeded")
+			return nil, false
+		}
+		if err != nil {
+			slog.Error("Failed to introspect token", "error", err)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			fmt.Fprintln(w, "Service Unavailable: Token introspection failed")
+			return nil, false
+		}
+		if !result.Active {
+			s.recordAuthFailure(clientIP)
+			s.rejectUnauthorized(w, "Inactive token")
+			return nil, false
+		}
+		s.recordAuthSuccess(clientIP)
+		identity, err := s.introspector.Identity(result)
+		if err != nil {
+			s.rejectForbidden(w, err.Error())
+			return nil, false
 		}
+		return identity, true
 	}
+	if apiKey == nil {
+		s.recordAuthFailure(clientIP)
+		s.rejectUnauthorized(w, "Invalid API key")
+		return nil, false
+	}
+	if err := apiKey.CheckValidity(time.Now()); err != nil {
+		s.rejectUnauthorized(w, err.Error())
+		return nil, false
+	}
+	if apiKey.RequireClientCertAndKey && !apiKey.MatchesClientCert(r.TLS) {
+		s.rejectUnauthorized(w, "Client certificate required in addition to API key")
+		return nil, false
+	}
+	if allowed, reason := apiKey.CheckClientIP(clientIP); !allowed {
+		s.rejectForbiddenIP(w, clientIP, apiKey.Name, reason)
+		return nil, false
+	}
+	s.recordAuthSuccess(clientIP)
+	s.keyStore.MarkUsed(apiKey.Name)
+	return NewKeyIdentity(apiKey, AuthMethodApiKey), true
+}
 
-	r.Header.Del("Authorization")
+// checkGlobalIPRules checks the client IP with the global allowlist, unless the given key overrides it,
+// returns false when the request got rejected. The global deny rules can't be overridden and are checked before.
+func (s *ServerHandler) checkGlobalIPRules(w http.ResponseWriter, clientIP netip.Addr, key *ApiKey) bool {
+	if key != nil && key.OverrideGlobalCidrs {
+		return true
+	}
+	if allowed, reason := s.ipRules.CheckAllow(clientIP); !allowed {
+		s.rejectForbiddenIP(w, clientIP, "", reason)
+		return false
+	}
+	return true
+}
 
+// rejectBlockedClient replies to a request of a client that is blocked due to failed authentication attempts,
+// returns true when the request got rejected.
+func (s *ServerHandler) rejectBlockedClient(w http.ResponseWriter, clientIP netip.Addr) bool {
+	if s.bruteForce == nil {
+		return false
+	}
+	blocked, wait := s.bruteForce.Check(clientIP)
+	if !blocked {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	w.WriteHeader(http.StatusTooManyRequests)
+	fmt.Fprintln(w, "Too Many Requests: Too many failed authentication attempts")
+	slog.Info("Too Many Requests: Client blocked due to failed authentication attempts", "clientIp", clientIP, "retryAfter", wait)
 	return true
 }
 
-func (s *ServerHandler) isUpstreamRunning() bool {
-	if s.upstreamBaseURL == nil {
-		slog.Error("Failed to ping unknown upstream")
+// recordAuthFailure records a failed authentication attempt of the client
+func (s *ServerHandler) recordAuthFailure(clientIP netip.Addr) {
+	if s.bruteForce != nil {
+		s.bruteForce.Failure(clientIP)
+	}
+}
+
+// recordAuthSuccess records a successful authentication of the client
+func (s *ServerHandler) recordAuthSuccess(clientIP netip.Addr) {
+	if s.bruteForce != nil {
+		s.bruteForce.Success(clientIP)
+	}
+}
+
+// rejectUnauthorized replies to an unauthorized request
+func (s *ServerHandler) rejectUnauthorized(w http.ResponseWriter, reason string) {
+	w.WriteHeader(http.StatusUnauthorized)
+	fmt.Fprintf(w, "Unauthorized: %s\n", reason)
+	slog.Info(fmt.Sprintf("Unauthorized: %s", reason))
+}
+
+// rejectForbidden replies to a request of an authenticated caller that may not use the proxy at all
+func (s *ServerHandler) rejectForbidden(w http.ResponseWriter, reason string) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintf(w, "Forbidden: %s\n", reason)
+	slog.Info(fmt.Sprintf("Forbidden: %s", reason))
+}
+
+// rejectForbiddenIP replies to a request from a client IP that isn't allowed, globally or for the given key
+func (s *ServerHandler) rejectForbiddenIP(w http.ResponseWriter, clientIP netip.Addr, keyName string, reason string) {
+	w.WriteHeader(http.StatusForbidden)
+	fmt.Fprintln(w, "Forbidden: Client IP not allowed")
+	if len(keyName) > 0 {
+		slog.Warn("Forbidden: Client IP not allowed for key", "clientIp", clientIP, "key", keyName, "reason", reason)
+	} else {
+		slog.Warn("Forbidden: Client IP not allowed", "clientIp", clientIP, "reason", reason)
+	}
+}
+
+// authorizeRequest checks if the identity may use the route and model of the request,
+// returns false when the request got rejected.
+func (s *ServerHandler) authorizeRequest(w http.ResponseWriter, r *http.Request, identity *Identity, logger *slog.Logger) bool {
+	scope := requiredScope(r.Method, r.URL.Path)
+	if !identity.HasScope(scope) {
+		logger.Warn("Forbidden: Missing scope", "identity", identity.Name, "scope", scope)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Forbidden: Missing scope %s\n", scope)
 		return false
 	}
-	resp, err := http.Get(s.upstreamBaseURL.String())
+	if len(identity.AllowedModels) == 0 || r.Method != http.MethodPost {
+		return true
+	}
+	data, err := readRequestBody(r)
 	if err != nil {
-		slog.Error("Failed to ping upstream", "error", err)
+		logger.Error("Failed to read request body for authorization", "error", err)
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintln(w, "Bad Request: Failed to read body")
 		return false
 	}
-	isSuccessStatus := resp.StatusCode/100 == 2
-	if !isSuccessStatus {
-		slog.Error("Failed to ping upstream", "status", resp.StatusCode)
-	} else {
-		s.lastSuccessfulPingTime = time.Now()
+	body := decodeJsonObject(data)
+	for _, field := range modelFields {
+		model, _ := body[field].(string)
+		if len(model) == 0 || identity.IsModelAllowed(model) {
+			continue
+		}
+		if resolved, found := s.modelAliases.Resolve(model); found && identity.IsModelAllowed(resolved) {
+			continue
+		}
+		logger.Warn("Forbidden: Model not allowed", "identity", identity.Name, "model", model)
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprintf(w, "Forbidden: Model %s not allowed\n", model)
+		return false
 	}
+	return true
+}
 
-	return isSuccessStatus
+// moderationRejection is the reply to a request rejected by content moderation
+type moderationRejection struct {
+	status  int
+	message string
+}
+
+// moderateRequest checks the prompt of the request with the moderator and returns the rejection when
+// the request got rejected. The returned flag is non-empty when the prompt got flagged but the request was accepted.
+func (s *ServerHandler) moderateRequest(r *http.Request, logger *slog.Logger) (string, *moderationRejection) {
+	if r.Method != http.MethodPost {
+		return "", nil
+	}
+	data, err := readRequestBody(r)
+	if err != nil {
+		logger.Error("Failed to read request body for moderation", "error", err)
+		return "", nil
+	}
+	body := decodeJsonObject(data)
+	if body == nil {
+		return "", nil
+	}
+	text := extractRequestText(r.URL.Path, body)
+	if len(text) == 0 {
+		return "", nil
+	}
+
+	result, err := s.moderation.Moderator.Moderate(r.Context(), text)
+	if err != nil {
+		logger.Error("Failed to moderate request", "error", err)
+		if s.moderation.FailClosed {
+			return "", &moderationRejection{http.StatusServiceUnavailable, "Service Unavailable: Content moderation failed"}
+		}
+		return "", nil
+	}
+	if !result.Flagged {
+		return "", nil
+	}
+	if s.moderation.Action == ModerationBlock {
+		logger.Warn("Rejected request by content moderation", "reason", result.Reason)
+		return "", &moderationRejection{http.StatusForbidden, "Forbidden: Request rejected by content moderation"}
+	}
+	logger.Warn("Flagged request by content moderation", "reason", result.Reason)
+	return result.Reason, nil
+}
+
+// writeAuditRecord writes the audit record of a request that got rejected before reaching the upstream
+func (s *ServerHandler) writeAuditRecord(r *http.Request, requestId string, identity *Identity, status int, errorText string) {
+	data, err := readRequestBody(r)
+	if err != nil {
+		slog.Error("Failed to read request body for audit log", "error", err)
+	}
+	record := newAuditRecord(r, requestId, identity, data)
+	record.Status = status
+	record.Error = errorText
+	if err := s.auditLog.Log(*record); err != nil {
+		slog.Error("Failed to write audit log", "error", err)
+	}
+}
+
+// isUpstreamRunning checks the latest result of the upstream health checker
+func (s *ServerHandler) isUpstreamRunning() bool {
+	return s.health != nil && s.health.Healthy()
 }
 
 func (s *ServerHandler) PreLoadModels(ctx context.Context) {
@@ -192,43 +713,56 @@ func (s *ServerHandler) PreLoadModels(ctx context.Context) {
 		if s.isUpstreamRunning() {
 			break
 		}
-		time.Sleep(2 * time.Second)
+		select {
+		case <-ctx.Done():
+			slog.Info("Cancelled preloading models")
+			return
+		case <-time.After(2 * time.Second):
+		}
 	}
 
-	s.preloadModelStatus = InProgress
+	s.preloads.Start()
 
-	slog.Info(fmt.Sprintf("Preloading %d models...", len(s.preloadModels)))
+	workers := max(min(s.preloadPulls.Concurrency, len(s.preloadModels)), 1)
+	slog.Info(fmt.Sprintf("Preloading %d models with %d workers...", len(s.preloadModels), workers))
 	client := api.NewClient(s.upstreamBaseURL, http.DefaultClient)
-	for _, model := range s.preloadModels {
-		pullRequest := &api.PullRequest{
-			Model: model,
-		}
-		var lastTotal int64 = -1
-		var lastProgressPercentage int64 = -1
-		progressFunc := func(resp api.ProgressResponse) error {
-			if resp.Total != lastTotal {
-				lastTotal = resp.Total
+	models := make(chan string)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for model := range models {
+				s.preloadModel(ctx, client, model)
 			}
-			if resp.Completed > 0 {
-				progressPercentage := int64(float64(resp.Completed) / float64(lastTotal) * 100)
-				if progressPercentage != lastProgressPercentage {
-					lastProgressPercentage = progressPercentage
-					slog.Info("Loading", "model", model, "status", resp.Status, "progressPercentage", fmt.Sprintf("%d%%", progressPercentage))
-				}
-			}
-			return nil
-		}
-		slog.Info(fmt.Sprintf("Loading model %s...", model))
-		err := client.Pull(ctx, pullRequest, progressFunc)
-		if err != nil {
-			slog.Error("Failed to pull", "model", model, "error", err)
-		} else {
-			slog.Info(fmt.Sprintf("Loaded model %s", model))
+		}()
+	}
+queue:
+	for _, model := range s.preloadModels {
+		select {
+		case models <- model:
+		case <-ctx.Done():
+			break queue
 		}
 	}
+	close(models)
+	wg.Wait()
+	if ctx.Err() != nil {
+		slog.Info("Cancelled preloading models")
+		return
+	}
 
-	s.preloadModelStatus = Preloaded
-	slog.Info(fmt.Sprintf("Preloaded %d models", len(s.preloadModels)))
+	if failed, total := s.preloads.Failed(); failed > 0 {
+		slog.Error(fmt.Sprintf("Preloaded %d models, %d of %d failed", total-failed, failed, total))
+	} else {
+		slog.Info(fmt.Sprintf("Preloaded %d models", total))
+	}
+	if s.preloadWarmUp.Enabled && s.preloadWarmUp.KeeperInterval > 0 {
+		go s.keepModelsLoaded(ctx, client)
+	}
+	if s.preloadRefresh.Interval > 0 {
+		go s.refreshModels(ctx, client)
+	}
 
 	listResponse, err := client.List(ctx)
 	if err != nil {
@@ -245,8 +779,96 @@ func (s *ServerHandler) PreLoadModels(ctx context.Context) {
 	}
 }
 
+// preloadModel pulls the model, retrying failed pulls with back-off, and warms it up when enabled
+func (s *ServerHandler) preloadModel(ctx context.Context, client *api.Client, model string) {
+	for attempt := 1; ; attempt++ {
+		err := s.pullModel(ctx, client, model)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			s.preloads.Update(model, PreloadFailed, ctx.Err())
+			return
+		}
+		if attempt > s.preloadPulls.Retries {
+			slog.Error("Failed to pull", "model", model, "attempts", attempt, "error", err)
+			s.preloads.Update(model, PreloadFailed, err)
+			return
+		}
+		delay := s.preloadPulls.RetryDelay(attempt)
+		slog.Warn("Failed to pull, retrying", "model", model, "attempt", attempt, "retryIn", delay, "error", err)
+		s.preloads.Retry(model, err)
+		select {
+		case <-ctx.Done():
+			s.preloads.Update(model, PreloadFailed, ctx.Err())
+			return
+		case <-time.After(delay):
+		}
+	}
+	slog.Info(fmt.Sprintf("Loaded model %s", model))
+	s.preloads.Update(model, PreloadPulled, nil)
+	if s.preloadWarmUp.Enabled {
+		s.preloads.Update(model, PreloadWarming, nil)
+		slog.Info(fmt.Sprintf("Warming up model %s...", model))
+		if err := s.warmUpModel(ctx, client, model); ctx.Err() != nil {
+			s.preloads.Update(model, PreloadFailed, ctx.Err())
+			return
+		} else if err != nil {
+			slog.Warn("Failed to warm up", "model", model, "error", err)
+			s.preloads.WarmUpFailed(model, err)
+			return
+		}
+		slog.Info(fmt.Sprintf("Warmed up model %s", model))
+	}
+	s.preloads.Update(model, PreloadReady, nil)
+}
+
+// pullModel pulls the model once, tracking the progress of the model and of preloading all models
+func (s *ServerHandler) pullModel(ctx context.Context, client *api.Client, model string) error {
+	pullRequest := &api.PullRequest{
+		Model: model,
+	}
+	// ollama reports the progress per layer of the model
+	layers := make(map[string]api.ProgressResponse)
+	lastProgressPercentage := -1
+	lastOverallPercentage := -1
+	progressFunc := func(resp api.ProgressResponse) error {
+		s.pulls.Progress(model, resp.Status, resp.Completed, resp.Total)
+		if len(resp.Digest) == 0 || resp.Total <= 0 {
+			return nil
+		}
+		layers[resp.Digest] = resp
+		var completed, total int64
+		for _, layer := range layers {
+			completed += layer.Completed
+			total += layer.Total
+		}
+		progressPercentage := s.preloads.Progress(model, completed, total)
+		if progressPercentage != lastProgressPercentage {
+			lastProgressPercentage = progressPercentage
+			slog.Info("Loading", "model", model, "status", resp.Status, "progressPercentage", fmt.Sprintf("%d%%", progressPercentage))
+		}
+		if overall := s.preloads.Overall(); overall.Progress != lastOverallPercentage && len(s.preloadModels) > 1 {
+			lastOverallPercentage = overall.Progress
+			slog.Info("Preloading", "models", overall.Models, "ready", overall.Ready, "failed", overall.Failed,
+				"progressPercentage", fmt.Sprintf("%d%%", overall.Progress))
+		}
+		return nil
+	}
+	slog.Info(fmt.Sprintf("Loading model %s...", model))
+	s.preloads.Update(model, PreloadPulling, nil)
+	if !s.pulls.Start(model) {
+		return errPullInProgress
+	}
+	err := client.Pull(ctx, pullRequest, progressFunc)
+	s.pulls.Finish(model, err)
+	return err
+}
+
 // forwardUserModelMetrics forwards the give ollama usage metrics to selected webhook.
 func (s *ServerHandler) forwardUserModelMetrics(userModelMetrics UserModelMetrics) {
+	s.keyUsage.Record(userModelMetrics)
+
 	if len(s.userModelMetricsWebhookUrl) == 0 {
 		slog.Debug("Skip forwarding user model metrics: no webhook url")
 		return
@@ -282,12 +904,25 @@ func (s *ServerHandler) forwardUserModelMetrics(userModelMetrics UserModelMetric
 	}
 }
 
-// requireApiKeyAuthorization checks if authentication with API key is required.
+// requireApiKeyAuthorization checks if authentication with API key or token is required.
+// Keys managed by key store file or admin API always require authentication, even when there is no key left.
 func (s *ServerHandler) requireApiKeyAuthorization() bool {
-	return len(s.apiKeys) > 0
+	return s.keyStore.Len() > 0 || len(s.keyStore.Path()) > 0 || len(s.adminApiKey) > 0 ||
+		s.jwtValidator != nil || s.introspector != nil
+}
+
+// piiRedactionFor returns whether PII gets redacted for requests of given identity.
+func (s *ServerHandler) piiRedactionFor(identity *Identity) bool {
+	if identity != nil && identity.Key != nil && identity.Key.PiiRedaction != nil {
+		return *identity.Key.PiiRedaction
+	}
+	return s.piiRedaction
 }
 
-// isValidAPIKey checks if the provided API key is valid.
-func (s *ServerHandler) isValidAPIKey(apiKey string) bool {
-	return slices.Contains(s.apiKeys, strings.TrimSpace(apiKey))
+// rewritePolicyFor returns the rewrite policy for requests of given identity.
+func (s *ServerHandler) rewritePolicyFor(identity *Identity) *RewritePolicy {
+	if identity == nil || identity.Key == nil || identity.Key.Rewrite == nil {
+		return s.rewritePolicy
+	}
+	return s.rewritePolicy.Merge(identity.Key.Rewrite)
 }
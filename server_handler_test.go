@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// newTestServerHandler creates a server handler accepting the given keys
+func newTestServerHandler(t *testing.T, keys ...*ApiKey) *ServerHandler {
+	t.Helper()
+	for _, key := range keys {
+		if err := key.validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServerHandler(NewKeyStore(keys), nil)
+}
+
+// authenticate runs the authentication of the server handler for the given request
+func authenticate(s *ServerHandler, r *http.Request) (*Identity, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	identity, _ := s.authRequestHandle(w, r, s.credentials.Take(r))
+	return identity, w
+}
+
+// withClientCert adds a verified client certificate with the given common name to the request
+func withClientCert(t *testing.T, r *http.Request, commonName string) *http.Request {
+	t.Helper()
+	cert, _ := newTestCertificate(t, commonName)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	return r
+}
+
+func TestAuthenticateClientCertificate(t *testing.T) {
+	s := newTestServerHandler(t,
+		&ApiKey{Name: "svc-embedder", ClientCertSubjects: []string{"embedder.internal"}, Scopes: []string{ScopeEmbed}},
+		&ApiKey{Name: "svc-admin", Key: "admin-key", ClientCertSubjects: []string{"admin.internal"}, RequireClientCertAndKey: true},
+	)
+
+	r := withClientCert(t, httptest.NewRequest(http.MethodPost, "/api/embed", nil), "embedder.internal")
+	identity, w := authenticate(s, r)
+	if identity == nil || identity.Name != "svc-embedder" || identity.AuthMethod != AuthMethodClientCert {
+		t.Errorf("identity = %+v, status %d, want identity of client certificate", identity, w.Code)
+	}
+
+	r = withClientCert(t, httptest.NewRequest(http.MethodPost, "/api/embed", nil), "unknown.internal")
+	if identity, w := authenticate(s, r); identity != nil || w.Code != http.StatusUnauthorized {
+		t.Errorf("unknown client certificate: identity = %+v, status %d, want 401", identity, w.Code)
+	}
+}
+
+func TestAuthenticateClientCertificateAndKey(t *testing.T) {
+	s := newTestServerHandler(t,
+		&ApiKey{Name: "svc-admin", Key: "admin-key", ClientCertSubjects: []string{"admin.internal"}, RequireClientCertAndKey: true},
+	)
+
+	r := withClientCert(t, httptest.NewRequest(http.MethodGet, "/api/tags", nil), "admin.internal")
+	if identity, w := authenticate(s, r); identity != nil || w.Code != http.StatusUnauthorized {
+		t.Errorf("certificate without key: identity = %+v, status %d, want 401", identity, w.Code)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/api/tags", nil)
+	r.Header.Set("Authorization", "Bearer admin-key")
+	if identity, w := authenticate(s, r); identity != nil || w.Code != http.StatusUnauthorized {
+		t.Errorf("key without certificate: identity = %+v, status %d, want 401", identity, w.Code)
+	}
+
+	r = withClientCert(t, httptest.NewRequest(http.MethodGet, "/api/tags", nil), "admin.internal")
+	r.Header.Set("Authorization", "Bearer admin-key")
+	if identity, w := authenticate(s, r); identity == nil || identity.Name != "svc-admin" {
+		t.Errorf("certificate and key: identity = %+v, status %d, want svc-admin", identity, w.Code)
+	}
+}
+
+func TestClientCertNamesRequireVerifiedChain(t *testing.T) {
+	cert, _ := newTestCertificate(t, "embedder.internal", "embedder.example.com")
+
+	if names := clientCertNames(&tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}); names != nil {
+		t.Errorf("names of unverified certificate = %v, want none", names)
+	}
+	names := clientCertNames(&tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}})
+	if len(names) != 2 || names[0] != "embedder.internal" || names[1] != "embedder.example.com" {
+		t.Errorf("names = %v, want common name and SAN", names)
+	}
+}
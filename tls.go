@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CertificateReloader provides a certificate loaded from cert/key files
+// and reloads it whenever one of the files changes.
+type CertificateReloader struct {
+	certFile string
+	keyFile  string
+
+	mutex   sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertificateReloader will create a new reloader and load the certificate initially
+func NewCertificateReloader(certFile string, keyFile string) (*CertificateReloader, error) {
+	c := &CertificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// GetCertificate returns the current certificate, usable as tls.Config.GetCertificate
+func (c *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.cert, nil
+}
+
+// Watch checks the cert/key files for changes in the given interval until the context is done
+func (c *CertificateReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			modTime, err := c.latestModTime()
+			if err != nil {
+				slog.Error("Failed to check TLS certificate", "error", err)
+				continue
+			}
+			c.mutex.RLock()
+			changed := modTime.After(c.modTime)
+			c.mutex.RUnlock()
+			if changed {
+				if err := c.reload(); err != nil {
+					slog.Error("Failed to reload TLS certificate, keep using previous one", "error", err)
+				} else {
+					slog.Info(fmt.Sprintf("Reloaded TLS certificate %s", c.certFile))
+				}
+			}
+		}
+	}
+}
+
+func (c *CertificateReloader) reload() error {
+	modTime, err := c.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate %s: %w", c.certFile, err)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.cert = &cert
+	c.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the latest modification time of the cert/key files
+func (c *CertificateReloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{c.certFile, c.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return latest, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// NewTLSConfig will create a TLS config serving the certificate of the reloader
+func NewTLSConfig(reloader *CertificateReloader, minVersion uint16, cipherSuites []uint16) *tls.Config {
+	return &tls.Config{
+		GetCertificate: reloader.GetCertificate,
+		MinVersion:     minVersion,
+		CipherSuites:   cipherSuites,
+	}
+}
+
+// loadCertPool loads a pool of CA certificates from a PEM bundle file
+func loadCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA bundle %s: %w", path, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in CA bundle %s", path)
+	}
+	return pool, nil
+}
+
+// parseTLSVersion parses a TLS version like "1.2"
+func parseTLSVersion(version string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(version), "tls") {
+	case "1.0", "10":
+		return tls.VersionTLS10, nil
+	case "1.1", "11":
+		return tls.VersionTLS11, nil
+	case "1.2", "12":
+		return tls.VersionTLS12, nil
+	case "1.3", "13":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unknown TLS version %q", version)
+}
+
+// parseCipherSuites parses a comma separated list of cipher suite names like "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"
+func parseCipherSuites(names string) ([]uint16, error) {
+	known := make(map[string]uint16)
+	tls13 := make(map[string]bool)
+	for _, suite := range tls.CipherSuites() {
+		if slices.Equal(suite.SupportedVersions, []uint16{tls.VersionTLS13}) {
+			// the TLS 1.3 suites aren't configurable
+			tls13[suite.Name] = true
+			continue
+		}
+		known[suite.Name] = suite.ID
+	}
+	suites := make([]uint16, 0)
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if tls13[name] {
+			return nil, fmt.Errorf("TLS 1.3 cipher suite %q isn't configurable", name)
+		}
+		id, found := known[name]
+		if !found {
+			return nil, fmt.Errorf("unknown or insecure TLS cipher suite %q", name)
+		}
+		suites = append(suites, id)
+	}
+	return suites, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestCertificate creates a self-signed certificate for the given common name and DNS names
+func newTestCertificate(t *testing.T, commonName string, dnsNames ...string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// writeTestCertificate writes a new self-signed certificate and its key as PEM files
+func writeTestCertificate(t *testing.T, certFile string, keyFile string, commonName string) {
+	t.Helper()
+	cert, key := newTestCertificate(t, commonName)
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := os.WriteFile(certFile, certPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, keyPem, 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// servedCommonName returns the common name of the certificate currently served by the reloader
+func servedCommonName(t *testing.T, reloader *CertificateReloader) string {
+	t.Helper()
+	cert, err := reloader.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestCertificateReloaderReloadsChangedFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "first")
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Fatalf("served %q, want first certificate", name)
+	}
+
+	writeTestCertificate(t, certFile, keyFile, "second")
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go reloader.Watch(ctx, 10*time.Millisecond)
+
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if servedCommonName(t, reloader) == "second" {
+			return
+		}
+	}
+	t.Error("changed certificate not reloaded")
+}
+
+func TestCertificateReloaderKeepsCertificateOnInvalidFiles(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	writeTestCertificate(t, certFile, keyFile, "first")
+	reloader, err := NewCertificateReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	os.WriteFile(certFile, []byte("broken"), 0o600)
+	if err := reloader.reload(); err == nil {
+		t.Fatal("invalid certificate loaded")
+	}
+
+	if name := servedCommonName(t, reloader); name != "first" {
+		t.Errorf("served %q, want previous certificate", name)
+	}
+}
+
+func TestParseTLSSettings(t *testing.T) {
+	if version, err := parseTLSVersion("TLS1.3"); err != nil || version != tls.VersionTLS13 {
+		t.Errorf("version = %x, err = %v, want TLS 1.3", version, err)
+	}
+	if _, err := parseTLSVersion("1.4"); err == nil {
+		t.Error("unknown TLS version accepted")
+	}
+	suites, err := parseCipherSuites("TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384")
+	if err != nil || len(suites) != 2 {
+		t.Errorf("suites = %v, err = %v, want two suites", suites, err)
+	}
+	if _, err := parseCipherSuites("TLS_RSA_WITH_RC4_128_SHA"); err == nil {
+		t.Error("insecure cipher suite accepted")
+	}
+	if _, err := parseCipherSuites("TLS_AES_128_GCM_SHA256"); err == nil {
+		t.Error("TLS 1.3 cipher suite accepted")
+	}
+}